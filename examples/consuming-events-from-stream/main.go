@@ -2,12 +2,11 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/thisisbud/backend-events-sidecar/pkg/budevents"
+	"golang.org/x/sync/errgroup"
 	"log"
-	"os"
 	"time"
 )
 
@@ -16,15 +15,29 @@ func main() {
 
 	flag.Parse()
 
-	conf, err := loadConfig(*configFilename)
+	conf, err := budevents.LoadListeners(*configFilename)
 
 	if err != nil {
 		log.Panic(err)
 	}
 
-	consumer := budevents.NewConsumer(printEvents, conf...)
+	consumer, err := budevents.NewConsumer(printEvents, conf...)
 
-	log.Panic(consumer.Consume(context.Background()))
+	if err != nil {
+		log.Panic(err)
+	}
+
+	errs, ctx := errgroup.WithContext(context.Background())
+	errs.Go(func() error {
+		return consumer.Consume(ctx)
+	})
+	errs.Go(func() error {
+		return budevents.WatchConfig(ctx, consumer, *configFilename, 5*time.Second, func(err error) {
+			log.Printf("ignoring config change: %s", err)
+		})
+	})
+
+	log.Panic(errs.Wait())
 }
 
 func printEvents(ctx context.Context, events ...budevents.Event) error {
@@ -33,19 +46,3 @@ func printEvents(ctx context.Context, events ...budevents.Event) error {
 	}
 	return ctx.Err()
 }
-
-func loadConfig(filename string) ([]budevents.Listener, error) {
-	blob, err := os.ReadFile(filename)
-
-	if err != nil {
-		return nil, err
-	}
-
-	var results []budevents.Listener
-
-	if err := json.Unmarshal(blob, &results); err != nil {
-		return nil, err
-	}
-
-	return results, nil
-}
@@ -9,7 +9,6 @@ import (
 	"golang.org/x/sync/errgroup"
 	"log"
 	"net/http"
-	"os"
 	"sort"
 	"sync"
 	"time"
@@ -23,7 +22,7 @@ func main() {
 
 	flag.Parse()
 
-	conf, err := loadConfig(*configFilename)
+	conf, err := budevents.LoadListeners(*configFilename)
 
 	if err != nil {
 		log.Panic(err)
@@ -34,7 +33,7 @@ func main() {
 		applications: map[string]ApplicationView{},
 	}
 
-	consumer := budevents.NewConsumer(func(ctx context.Context, events ...budevents.Event) error {
+	consumer, err := budevents.NewConsumer(func(ctx context.Context, events ...budevents.Event) error {
 		for _, event := range events {
 			if err := resp.on(event); err != nil {
 				return err
@@ -43,15 +42,25 @@ func main() {
 		return nil
 	}, conf...)
 
+	if err != nil {
+		log.Panic(err)
+	}
+
 	r := chi.NewRouter()
 	r.Get("/loan-applications", func(w http.ResponseWriter, r *http.Request) {
 		resp.render(w)
 	})
+	r.Handle("/metrics", consumer.MetricsHandler())
 
 	errs, ctx := errgroup.WithContext(context.Background())
 	errs.Go(func() error {
 		return consumer.Consume(ctx)
 	})
+	errs.Go(func() error {
+		return budevents.WatchConfig(ctx, consumer, *configFilename, 5*time.Second, func(err error) {
+			log.Printf("ignoring config change: %s", err)
+		})
+	})
 	errs.Go(func() error {
 		return http.ListenAndServe(":"+*port, r)
 	})
@@ -166,19 +175,3 @@ type ApplicationView struct {
 	LoanType      string `json:"loan_type"`
 	Status        string `json:"status"`
 }
-
-func loadConfig(filename string) ([]budevents.Listener, error) {
-	blob, err := os.ReadFile(filename)
-
-	if err != nil {
-		return nil, err
-	}
-
-	var results []budevents.Listener
-
-	if err := json.Unmarshal(blob, &results); err != nil {
-		return nil, err
-	}
-
-	return results, nil
-}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/thisisbud/backend-events-sidecar/internal/schema"
+	"go/format"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// initialisms are written in upper case in Go identifiers
+var initialisms = map[string]bool{
+	"API":  true,
+	"HTTP": true,
+	"ID":   true,
+	"JSON": true,
+	"SKU":  true,
+	"URL":  true,
+	"UUID": true,
+}
+
+func generate(packageName string, schemas []eventSchema) ([]byte, error) {
+	if len(schemas) == 0 {
+		return nil, fmt.Errorf("no schemas found")
+	}
+
+	g := generator{imports: map[string]bool{}, declared: map[*schema.Schema]string{}, claimed: map[string]string{}}
+
+	for _, event := range schemas {
+		g.event(event)
+	}
+
+	if g.err != nil {
+		return nil, g.err
+	}
+
+	var out bytes.Buffer
+
+	out.WriteString("// Code generated by budevents-gen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&out, "package %s\n\n", packageName)
+	out.WriteString("import (\n")
+
+	imports := []string{"context", "encoding/json", "fmt", "github.com/thisisbud/backend-events-sidecar/pkg/budevents"}
+
+	for path := range g.imports {
+		imports = append(imports, path)
+	}
+
+	sort.Strings(imports)
+
+	for _, path := range imports {
+		fmt.Fprintf(&out, "\t%q\n", path)
+	}
+
+	out.WriteString(")\n")
+
+	for _, decl := range g.decls {
+		out.WriteString("\n" + decl)
+	}
+
+	return format.Source(out.Bytes())
+}
+
+type generator struct {
+	imports map[string]bool
+	// declared holds the type names given to $ref targets, so that each is
+	// declared once and recursive schemas terminate
+	declared map[*schema.Schema]string
+	// claimed holds the event each package-level name was generated for, since
+	// names built from different events and properties can come out the same
+	claimed   map[string]string
+	decls     []string
+	root      *schema.Schema
+	eventName string
+	err       error
+}
+
+// claim reserves a package-level name, failing generation if another event
+// already has it
+func (g *generator) claim(name string) {
+	claimant, ok := g.claimed[name]
+
+	if ok && g.err == nil && claimant == g.eventName {
+		g.err = fmt.Errorf("the name [%s] is generated twice for the [%s] event", name, g.eventName)
+	} else if ok && g.err == nil {
+		g.err = fmt.Errorf("the name [%s] is generated for both the [%s] and [%s] events", name, claimant, g.eventName)
+	}
+
+	g.claimed[name] = g.eventName
+}
+
+func (g *generator) event(event eventSchema) {
+	g.root = event.schema
+	g.eventName = event.eventName
+	name := goName(event.eventName)
+	g.declared[event.schema] = name
+	g.declare(name, event.schema)
+
+	for _, claimed := range []string{name + "EventName", name + "Schema", "Decode" + name, "On" + name} {
+		g.claim(claimed)
+	}
+
+	var decl strings.Builder
+
+	fmt.Fprintf(&decl, "const (\n")
+	fmt.Fprintf(&decl, "\t%sEventName = %q\n", name, event.eventName)
+	fmt.Fprintf(&decl, "\t%sSchema = %q\n", name, schema.URL(event.eventName, event.version))
+	fmt.Fprintf(&decl, ")\n\n")
+
+	fmt.Fprintf(&decl, "// Decode%[1]s decodes the payload of %[2]s events\n", name, event.eventName)
+	fmt.Fprintf(&decl, "func Decode%[1]s(event budevents.Event) (%[1]s, error) {\n", name)
+	fmt.Fprintf(&decl, "\tvar payload %s\n\n", name)
+	fmt.Fprintf(&decl, "\tif err := json.Unmarshal(event.Payload, &payload); err != nil {\n")
+	fmt.Fprintf(&decl, "\t\treturn payload, fmt.Errorf(\"decoding %s event [%%s]: %%w\", event.EventID, err)\n", event.eventName)
+	fmt.Fprintf(&decl, "\t}\n\n")
+	fmt.Fprintf(&decl, "\treturn payload, nil\n")
+	fmt.Fprintf(&decl, "}\n\n")
+
+	fmt.Fprintf(&decl, "// On%[1]s registers a handler for %[2]s events, decoding their payload\n", name, event.eventName)
+	fmt.Fprintf(&decl, "func On%[1]s(\n", name)
+	fmt.Fprintf(&decl, "\trouter *budevents.Router,\n")
+	fmt.Fprintf(&decl, "\thandler func(ctx context.Context, event budevents.Event, payload %s) error,\n", name)
+	fmt.Fprintf(&decl, ") *budevents.Router {\n")
+	fmt.Fprintf(&decl, "\treturn router.On(%sEventName, func(ctx context.Context, event budevents.Event) error {\n", name)
+	fmt.Fprintf(&decl, "\t\tpayload, err := Decode%s(event)\n\n", name)
+	fmt.Fprintf(&decl, "\t\tif err != nil {\n\t\t\treturn err\n\t\t}\n\n")
+	fmt.Fprintf(&decl, "\t\treturn handler(ctx, event, payload)\n")
+	fmt.Fprintf(&decl, "\t})\n")
+	fmt.Fprintf(&decl, "}\n")
+
+	g.decls = append(g.decls, decl.String())
+}
+
+// declare adds a named type for a schema, declaring the types of its
+// properties after it
+func (g *generator) declare(name string, s *schema.Schema) {
+	g.claim(name)
+	position := len(g.decls)
+	g.decls = append(g.decls, "")
+
+	var decl strings.Builder
+
+	if s.Description != "" {
+		decl.WriteString(comment(s.Description, ""))
+	}
+
+	if values, ok := stringEnum(s); ok {
+		fmt.Fprintf(&decl, "type %s string\n\n", name)
+		fmt.Fprintf(&decl, "const (\n")
+
+		for _, value := range values {
+			g.claim(name + goName(value))
+			fmt.Fprintf(&decl, "\t%s%s %s = %q\n", name, goName(value), name, value)
+		}
+
+		fmt.Fprintf(&decl, ")\n")
+		g.decls[position] = decl.String()
+		return
+	}
+
+	if !isObject(s) || len(s.Properties) == 0 {
+		fmt.Fprintf(&decl, "type %s %s\n", name, g.typeOf(s, name))
+		g.decls[position] = decl.String()
+		return
+	}
+
+	fmt.Fprintf(&decl, "type %s struct {\n", name)
+
+	properties := make([]string, 0, len(s.Properties))
+
+	for property := range s.Properties {
+		properties = append(properties, property)
+	}
+
+	sort.Strings(properties)
+	fields := map[string]string{}
+
+	for _, property := range properties {
+		if other, ok := fields[goName(property)]; ok && g.err == nil {
+			g.err = fmt.Errorf("the properties [%s] and [%s] of [%s] both become the field [%s]", other, property, name, goName(property))
+		}
+
+		fields[goName(property)] = property
+
+		propertySchema := s.Properties[property]
+		fieldType := g.typeOf(propertySchema, name+goName(property))
+		tag := property
+
+		if !requires(s, property) {
+			tag += ",omitempty"
+
+			if nillable := strings.HasPrefix(fieldType, "[]") || strings.HasPrefix(fieldType, "map[") ||
+				fieldType == "json.RawMessage" || fieldType == "interface{}"; !nillable {
+				fieldType = "*" + fieldType
+			}
+		}
+
+		if propertySchema.Description != "" {
+			decl.WriteString(comment(propertySchema.Description, "\t"))
+		}
+
+		fmt.Fprintf(&decl, "\t%s %s `json:\"%s\"`\n", goName(property), fieldType, tag)
+	}
+
+	fmt.Fprintf(&decl, "}\n")
+	g.decls[position] = decl.String()
+}
+
+// typeOf returns the Go type for a schema, declaring a type called name when
+// it needs one
+func (g *generator) typeOf(s *schema.Schema, name string) string {
+	if s.Ref != "" {
+		target, err := g.root.Resolve(s.Ref)
+
+		if err != nil {
+			return "json.RawMessage"
+		}
+
+		if declared, ok := g.declared[target]; ok {
+			return declared
+		}
+
+		refName := g.declared[g.root] + goName(s.Ref[strings.LastIndex(s.Ref, "/")+1:])
+		g.declared[target] = refName
+		g.declare(refName, target)
+		return refName
+	}
+
+	if _, ok := stringEnum(s); ok || (isObject(s) && len(s.Properties) > 0) {
+		g.declare(name, s)
+		return name
+	}
+
+	switch nonNullType(s) {
+	case "string":
+		if s.Format == "date-time" {
+			g.imports["time"] = true
+			return "time.Time"
+		}
+
+		return "string"
+	case "integer":
+		return "int64"
+	case "number":
+		return "float64"
+	case "boolean":
+		return "bool"
+	case "array":
+		if s.Items == nil {
+			return "[]json.RawMessage"
+		}
+
+		return "[]" + g.typeOf(s.Items, name+"Item")
+	case "object":
+		if s.AdditionalProperties != nil {
+			return "map[string]" + g.typeOf(s.AdditionalProperties, name+"Value")
+		}
+
+		return "map[string]interface{}"
+	default:
+		return "json.RawMessage"
+	}
+}
+
+// nonNullType is the type of a schema, ignoring null, or empty if it allows
+// several types or none is given
+func nonNullType(s *schema.Schema) string {
+	var found []string
+
+	for _, name := range s.Type {
+		if name != "null" {
+			found = append(found, name)
+		}
+	}
+
+	if len(found) != 1 || len(s.AllOf)+len(s.AnyOf)+len(s.OneOf) > 0 {
+		return ""
+	}
+
+	return found[0]
+}
+
+func isObject(s *schema.Schema) bool {
+	return nonNullType(s) == "object" || (len(s.Type) == 0 && len(s.Properties) > 0)
+}
+
+func stringEnum(s *schema.Schema) ([]string, bool) {
+	if len(s.Enum) == 0 {
+		return nil, false
+	}
+
+	values := make([]string, len(s.Enum))
+
+	for i, value := range s.Enum {
+		str, ok := value.(string)
+
+		if !ok {
+			return nil, false
+		}
+
+		values[i] = str
+	}
+
+	return values, true
+}
+
+func requires(s *schema.Schema, property string) bool {
+	for _, required := range s.Required {
+		if required == property {
+			return true
+		}
+	}
+
+	return false
+}
+
+// goName turns snake_case, kebab-case or camelCase into an exported Go name
+func goName(s string) string {
+	var words []string
+	var word []rune
+
+	for i, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			words, word = appendWord(words, word), nil
+			continue
+		}
+
+		if unicode.IsUpper(r) && i > 0 && len(word) > 0 && unicode.IsLower(word[len(word)-1]) {
+			words, word = appendWord(words, word), nil
+		}
+
+		word = append(word, r)
+	}
+
+	words = appendWord(words, word)
+	name := strings.Join(words, "")
+
+	if name == "" || unicode.IsDigit(rune(name[0])) {
+		name = "X" + name
+	}
+
+	return name
+}
+
+func appendWord(words []string, word []rune) []string {
+	if len(word) == 0 {
+		return words
+	}
+
+	upper := strings.ToUpper(string(word))
+
+	if initialisms[upper] {
+		return append(words, upper)
+	}
+
+	return append(words, strings.ToUpper(string(word[0]))+strings.ToLower(string(word[1:])))
+}
+
+func comment(text string, indent string) string {
+	var out strings.Builder
+
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		out.WriteString(indent + "// " + strings.TrimSpace(line) + "\n")
+	}
+
+	return out.String()
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"github.com/thisisbud/backend-events-sidecar/internal/schema"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files from the generated output")
+
+func TestGenerateMatchesGoldenFileAndBuilds(t *testing.T) {
+	schemas, err := loadDir(filepath.Join("testdata", "schemas"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source, err := generate("events", schemas)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	golden := filepath.Join("testdata", "events", "events_gen.go")
+
+	if *update {
+		if err := os.WriteFile(golden, source, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want, err := os.ReadFile(golden)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(source, want) {
+		t.Errorf("generated code differs from [%s], run go test with -update to accept it:\n%s", golden, source)
+	}
+
+	// packages under testdata are left out of ./... but build when named
+	if out, err := exec.Command("go", "vet", "./testdata/events").CombinedOutput(); err != nil {
+		t.Errorf("the golden file does not build: %v\n%s", err, out)
+	}
+}
+
+func TestGenerateRejectsCollidingNames(t *testing.T) {
+	for name, test := range map[string]struct {
+		schemas map[string]string
+		want    string
+	}{
+		"an event and a property type": {
+			schemas: map[string]string{
+				"order":        `{"type":"object","properties":{"placed":{"type":"object","properties":{"at":{"type":"string"}}}}}`,
+				"order_placed": `{"type":"object","properties":{"order_id":{"type":"string"}}}`,
+			},
+			want: "[OrderPlaced]",
+		},
+		"an event and an enum value": {
+			schemas: map[string]string{
+				"order":                `{"type":"object","properties":{"status":{"enum":["pending"]}}}`,
+				"order_status_pending": `{"type":"object"}`,
+			},
+			want: "[OrderStatusPending]",
+		},
+		"two properties": {
+			schemas: map[string]string{
+				"order": `{"type":"object","properties":{"order_id":{"type":"string"},"orderId":{"type":"string"}}}`,
+			},
+			want: "[OrderID]",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var schemas []eventSchema
+
+			for _, eventName := range sortedKeys(test.schemas) {
+				payloadSchema, err := schema.Parse([]byte(test.schemas[eventName]))
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				schemas = append(schemas, eventSchema{eventName: eventName, version: 1, schema: payloadSchema})
+			}
+
+			if _, err := generate("events", schemas); err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("generated with [%v], want an error naming %s", err, test.want)
+			}
+		})
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,159 @@
+// Command budevents-gen generates Go payload types from event schemas, along
+// with a decode helper and a router registration function for each event
+//
+//	budevents-gen -dir schemas -package events -o events_gen.go
+//	budevents-gen -url http://localhost:8080 -package events -o events_gen.go
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/thisisbud/backend-events-sidecar/internal/schema"
+	"github.com/thisisbud/backend-events-sidecar/pkg/budevents"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+func main() {
+	dir := flag.String("dir", "", "directory of schemas, as <event_name>/<version>.json")
+	sidecarURL := flag.String("url", "", "base URL of a sidecar to fetch schemas from")
+	packageName := flag.String("package", "events", "package of the generated code")
+	output := flag.String("o", "", "file to write the generated code to, instead of stdout")
+
+	flag.Parse()
+
+	if (*dir == "") == (*sidecarURL == "") {
+		log.Fatal("exactly one of -dir or -url is required")
+	}
+
+	var schemas []eventSchema
+	var err error
+
+	if *dir != "" {
+		schemas, err = loadDir(*dir)
+	} else {
+		schemas, err = fetch(*sidecarURL)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	source, err := generate(*packageName, schemas)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *output == "" {
+		_, _ = os.Stdout.Write(source)
+		return
+	}
+
+	if err := os.WriteFile(*output, source, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// eventSchema is the latest version of the schema for an event name
+type eventSchema struct {
+	eventName string
+	version   int
+	schema    *schema.Schema
+}
+
+func loadDir(dir string) ([]eventSchema, error) {
+	registry, err := schema.LoadDir(dir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var schemas []eventSchema
+
+	for _, ref := range latest(registry.List()) {
+		payloadSchema, _ := registry.Get(ref.EventName, ref.Version)
+		schemas = append(schemas, eventSchema{
+			eventName: ref.EventName,
+			version:   ref.Version,
+			schema:    payloadSchema,
+		})
+	}
+
+	return schemas, nil
+}
+
+func fetch(sidecarURL string) ([]eventSchema, error) {
+	sidecarURL = strings.TrimSuffix(sidecarURL, "/")
+	blob, err := get(sidecarURL + "/v1/schemas")
+
+	if err != nil {
+		return nil, err
+	}
+
+	var index budevents.SchemaIndex
+
+	if err := json.Unmarshal(blob, &index); err != nil {
+		return nil, err
+	}
+
+	var schemas []eventSchema
+
+	for _, ref := range latest(index.Schemas) {
+		blob, err := get(sidecarURL + ref.Href)
+
+		if err != nil {
+			return nil, err
+		}
+
+		payloadSchema, err := schema.Parse(blob)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid schema [%s]: %w", ref.Href, err)
+		}
+
+		schemas = append(schemas, eventSchema{
+			eventName: ref.EventName,
+			version:   ref.Version,
+			schema:    payloadSchema,
+		})
+	}
+
+	return schemas, nil
+}
+
+func get(url string) ([]byte, error) {
+	resp, err := http.Get(url)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bad status code [%d] from [%s]", resp.StatusCode, url)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// latest keeps the highest version of each event name from a list ordered by
+// event name then version
+func latest(refs []budevents.SchemaReference) []budevents.SchemaReference {
+	var latest []budevents.SchemaReference
+
+	for i, ref := range refs {
+		if i+1 < len(refs) && refs[i+1].EventName == ref.EventName {
+			continue
+		}
+
+		latest = append(latest, ref)
+	}
+
+	return latest
+}
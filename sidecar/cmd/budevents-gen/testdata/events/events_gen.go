@@ -0,0 +1,109 @@
+// Code generated by budevents-gen. DO NOT EDIT.
+
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/thisisbud/backend-events-sidecar/pkg/budevents"
+	"time"
+)
+
+type CustomerRegistered struct {
+	CustomerID string  `json:"customer_id"`
+	Marketing  *bool   `json:"marketing,omitempty"`
+	Referrer   *string `json:"referrer,omitempty"`
+}
+
+const (
+	CustomerRegisteredEventName = "customer_registered"
+	CustomerRegisteredSchema    = "/v1/schemas/customer_registered/2"
+)
+
+// DecodeCustomerRegistered decodes the payload of customer_registered events
+func DecodeCustomerRegistered(event budevents.Event) (CustomerRegistered, error) {
+	var payload CustomerRegistered
+
+	if err := json.Unmarshal(event.Payload, &payload); err != nil {
+		return payload, fmt.Errorf("decoding customer_registered event [%s]: %w", event.EventID, err)
+	}
+
+	return payload, nil
+}
+
+// OnCustomerRegistered registers a handler for customer_registered events, decoding their payload
+func OnCustomerRegistered(
+	router *budevents.Router,
+	handler func(ctx context.Context, event budevents.Event, payload CustomerRegistered) error,
+) *budevents.Router {
+	return router.On(CustomerRegisteredEventName, func(ctx context.Context, event budevents.Event) error {
+		payload, err := DecodeCustomerRegistered(event)
+
+		if err != nil {
+			return err
+		}
+
+		return handler(ctx, event, payload)
+	})
+}
+
+type OrderPlaced struct {
+	Delivery *OrderPlacedDelivery `json:"delivery,omitempty"`
+	Discount *float64             `json:"discount,omitempty"`
+	Lines    []OrderPlacedLine    `json:"lines"`
+	// The order that was placed
+	OrderID  string            `json:"order_id"`
+	PlacedAt time.Time         `json:"placed_at"`
+	Status   OrderPlacedStatus `json:"status"`
+	Tags     map[string]string `json:"tags,omitempty"`
+}
+
+type OrderPlacedDelivery struct {
+	Address      *string `json:"address,omitempty"`
+	Instructions *string `json:"instructions,omitempty"`
+}
+
+type OrderPlacedLine struct {
+	Quantity int64  `json:"quantity"`
+	SKU      string `json:"sku"`
+}
+
+type OrderPlacedStatus string
+
+const (
+	OrderPlacedStatusPending   OrderPlacedStatus = "pending"
+	OrderPlacedStatusConfirmed OrderPlacedStatus = "confirmed"
+)
+
+const (
+	OrderPlacedEventName = "order_placed"
+	OrderPlacedSchema    = "/v1/schemas/order_placed/1"
+)
+
+// DecodeOrderPlaced decodes the payload of order_placed events
+func DecodeOrderPlaced(event budevents.Event) (OrderPlaced, error) {
+	var payload OrderPlaced
+
+	if err := json.Unmarshal(event.Payload, &payload); err != nil {
+		return payload, fmt.Errorf("decoding order_placed event [%s]: %w", event.EventID, err)
+	}
+
+	return payload, nil
+}
+
+// OnOrderPlaced registers a handler for order_placed events, decoding their payload
+func OnOrderPlaced(
+	router *budevents.Router,
+	handler func(ctx context.Context, event budevents.Event, payload OrderPlaced) error,
+) *budevents.Router {
+	return router.On(OrderPlacedEventName, func(ctx context.Context, event budevents.Event) error {
+		payload, err := DecodeOrderPlaced(event)
+
+		if err != nil {
+			return err
+		}
+
+		return handler(ctx, event, payload)
+	})
+}
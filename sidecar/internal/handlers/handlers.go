@@ -1,86 +1,424 @@
 package handlers
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/go-chi/chi/v5"
+	"github.com/thisisbud/backend-events-sidecar/internal/schema"
 	"github.com/thisisbud/backend-events-sidecar/internal/storage"
 	"github.com/thisisbud/backend-events-sidecar/pkg/budevents"
 	"net/http"
+	"reflect"
+	"sort"
+	"strings"
 	"time"
 )
 
 func Wellknown(w http.ResponseWriter, r *http.Request) {
-
+	w.Header().Set("Content-Type", budevents.ContentType)
+	_ = json.NewEncoder(w).Encode(budevents.Discovery{
+		Metadata: map[string]budevents.Reference{
+			"latest": {
+				Href: "/v1/events",
+				Type: http.MethodGet,
+			},
+			"schemas": {
+				Href: "/v1/schemas",
+				Type: http.MethodGet,
+			},
+		},
+	})
 }
 
-func GetLatestEvent(getLatestEvent storage.GetLatestEvent) http.HandlerFunc {
+// GetLatestEvent serves the head of the stream or, given ?at=<RFC 3339 time>,
+// the latest event published at or before that time
+func GetLatestEvent(getLatestEvent storage.GetLatestEvent, getEventAt storage.GetEventAt) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		event, refs, err := getLatestEvent(r.Context())
+		at := r.URL.Query().Get("at")
 
-		if errors.Is(err, storage.ErrEventNotFound) {
-			w.WriteHeader(http.StatusNotFound)
+		if at == "" {
+			event, refs, err := getLatestEvent(r.Context(), eventNames(r))
+			writeEvent(w, event, refs, err)
 			return
 		}
 
+		t, err := time.Parse(time.RFC3339Nano, at)
+
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			writeProblem(w, http.StatusBadRequest, budevents.ProblemInvalidQuery, "invalid query", budevents.Violation{
+				Field:   "at",
+				Message: "must be an RFC 3339 time",
+			})
 			return
 		}
 
-		w.Header().Set("Content-Type", budevents.ContentType)
-		_ = json.NewEncoder(w).Encode(budevents.Response{
-			Data:     *event,
-			Metadata: refs,
-		})
+		event, refs, err := getEventAt(r.Context(), t, eventNames(r))
+		writeEvent(w, event, refs, err)
 	}
 }
 
 func GetEvent(getEventByID storage.GetEvent) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		event, refs, err := getEventByID(r.Context(), chi.URLParam(r, "event_id"))
+		event, refs, err := getEventByID(r.Context(), chi.URLParam(r, "event_id"), eventNames(r))
+		writeEvent(w, event, refs, err)
+	}
+}
 
-		if errors.Is(err, storage.ErrEventNotFound) {
-			w.WriteHeader(http.StatusNotFound)
+func GetLatestSubjectEvent(getLatestSubjectEvent storage.GetLatestSubjectEvent) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		event, refs, err := getLatestSubjectEvent(r.Context(), chi.URLParam(r, "subject"))
+		writeEvent(w, event, refs, err)
+	}
+}
+
+func GetSubjectEvent(getSubjectEvent storage.GetSubjectEvent) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		event, refs, err := getSubjectEvent(r.Context(), chi.URLParam(r, "subject"), chi.URLParam(r, "event_id"))
+		writeEvent(w, event, refs, err)
+	}
+}
+
+// eventNames reads the ?event_name=a,b filter of a view of the stream, sorted
+// so that every request for the same view links to the same URLs
+func eventNames(r *http.Request) []string {
+	var names []string
+	seen := map[string]bool{}
+
+	for _, name := range strings.Split(r.URL.Query().Get("event_name"), ",") {
+		name = strings.TrimSpace(name)
+
+		if name != "" && !seen[name] {
+			names = append(names, name)
+			seen[name] = true
+		}
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+func writeEvent(w http.ResponseWriter, event *budevents.Event, refs map[string]budevents.Reference, err error) {
+	if errors.Is(err, storage.ErrEventNotFound) {
+		writeProblem(w, http.StatusNotFound, budevents.ProblemEventNotFound, "no event found")
+		return
+	}
+
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", budevents.ContentType)
+	w.Header().Set("ETag", `"`+event.EventID+`"`)
+	_ = json.NewEncoder(w).Encode(budevents.Response{
+		Schema:   event.Schema,
+		Data:     *event,
+		Metadata: refs,
+	})
+}
+
+// publishRequest is an event, optionally with the ID of the event it expects
+// to be published after (also accepted as an If-Match header naming a single
+// event)
+type publishRequest struct {
+	budevents.Event
+	ExpectedPreviousEventID string `json:"expected_previous_event_id"`
+}
+
+func PublishEvent(
+	publish storage.PublishEvent,
+	getEventByID storage.GetEvent,
+	schemas *schema.Registry,
+	newID func() string,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req publishRequest
+
+		if !decodeBody(w, r, &req) {
+			return
+		}
+
+		requested := req.Event
+
+		if violations := validateEvent(requested, ""); len(violations) > 0 {
+			writeProblem(w, http.StatusBadRequest, budevents.ProblemInvalidEvent, "the event is invalid", violations...)
+			return
+		}
+
+		body, violations := validatePayload(schemas, withDefaults(requested, newID), "")
+
+		if len(violations) > 0 {
+			writeProblem(w, http.StatusBadRequest, budevents.ProblemInvalidEvent, "the payload does not match its schema", violations...)
+			return
+		}
+
+		expected, ok := expectedPreviousEventID(w, r, req.ExpectedPreviousEventID)
+
+		if !ok {
+			return
+		}
+
+		published, err := publish(r.Context(), body, expected)
+
+		if errors.Is(err, storage.ErrDuplicateEvent) {
+			republishEvent(w, r, getEventByID, requested)
+			return
+		}
+
+		if errors.Is(err, storage.ErrPreconditionFailed) {
+			writePreconditionFailed(w)
 			return
 		}
 
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			writeInternalError(w, err)
 			return
 		}
 
-		w.Header().Set("Content-Type", budevents.ContentType)
-		_ = json.NewEncoder(w).Encode(budevents.Response{
-			Data:     *event,
-			Metadata: refs,
-		})
+		writeReference(w, http.StatusCreated, *published)
 	}
 }
 
-func PublishEvent(publish storage.PublishEvent, newID func() string) http.HandlerFunc {
+func PublishEvents(
+	publishEvents storage.PublishEvents,
+	getEventByID storage.GetEvent,
+	schemas *schema.Registry,
+	newID func() string,
+) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var body budevents.Event
+		var req budevents.BatchRequest
 
-		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-			w.WriteHeader(http.StatusBadRequest)
+		if !decodeBody(w, r, &req) {
 			return
 		}
 
-		if body.EventID == "" {
-			body.EventID = newID()
+		if len(req.Events) == 0 {
+			writeProblem(w, http.StatusBadRequest, budevents.ProblemInvalidBody, "the batch has no events")
+			return
 		}
 
-		if body.OccurredAt.IsZero() {
-			body.OccurredAt = time.Now().UTC()
+		events := make([]budevents.Event, len(req.Events))
+		eventIDs := map[string]bool{}
+		var violations []budevents.Violation
+
+		for i, requested := range req.Events {
+			prefix := fmt.Sprintf("events[%d].", i)
+			eventViolations := validateEvent(requested, prefix)
+			events[i] = withDefaults(requested, newID)
+
+			// only well-formed events are worth checking against their schema
+			if len(eventViolations) == 0 {
+				events[i], eventViolations = validatePayload(schemas, events[i], prefix)
+			}
+
+			violations = append(violations, eventViolations...)
+
+			if eventIDs[events[i].EventID] {
+				violations = append(violations, budevents.Violation{
+					Field:   prefix + "event_id",
+					Message: "appears more than once in the batch",
+				})
+			}
+
+			eventIDs[events[i].EventID] = true
+		}
+
+		if len(violations) > 0 {
+			writeProblem(w, http.StatusBadRequest, budevents.ProblemInvalidEvent, "the batch has invalid events", violations...)
+			return
+		}
+
+		expected, ok := expectedPreviousEventID(w, r, req.ExpectedPreviousEventID)
+
+		if !ok {
+			return
 		}
 
-		if err := publish(r.Context(), body); err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+		published, err := publishEvents(r.Context(), events, expected)
+
+		if errors.Is(err, storage.ErrDuplicateEvent) {
+			republishEvents(w, r, getEventByID, req.Events)
+			return
+		}
+
+		if errors.Is(err, storage.ErrPreconditionFailed) {
+			writePreconditionFailed(w)
+			return
+		}
+
+		if err != nil {
+			writeInternalError(w, err)
 			return
 		}
 
-		w.Header().Set("Location", "/v1/events/"+body.EventID)
-		w.WriteHeader(http.StatusCreated)
+		writeBatch(w, http.StatusCreated, published)
 	}
 }
+
+func withDefaults(event budevents.Event, newID func() string) budevents.Event {
+	if event.EventID == "" {
+		event.EventID = newID()
+	}
+
+	if event.OccurredAt.IsZero() {
+		event.OccurredAt = time.Now().UTC()
+	}
+
+	return event
+}
+
+// expectedPreviousEventID is the event the request expects to be published
+// after, responding with a problem if its If-Match header does not name a
+// single event. Matching any event with * or one of a list is not supported
+func expectedPreviousEventID(w http.ResponseWriter, r *http.Request, fromBody string) (string, bool) {
+	ifMatch := strings.TrimSpace(r.Header.Get("If-Match"))
+
+	if ifMatch == "" {
+		return fromBody, true
+	}
+
+	if ifMatch == "*" || strings.Contains(ifMatch, ",") {
+		writeProblem(w, http.StatusBadRequest, budevents.ProblemInvalidHeader, "If-Match must name a single event, not * or a list")
+		return "", false
+	}
+
+	return strings.Trim(strings.TrimPrefix(ifMatch, "W/"), `"`), true
+}
+
+// writeReference responds with the self link of a published event, which is
+// also its Location
+func writeReference(w http.ResponseWriter, status int, event budevents.Event) {
+	ref := selfReference(event)
+
+	w.Header().Set("Location", ref.Href)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(ref)
+}
+
+func writeBatch(w http.ResponseWriter, status int, events []budevents.Event) {
+	resp := budevents.BatchResponse{
+		Events: make([]budevents.Reference, len(events)),
+	}
+
+	for i, event := range events {
+		resp.Events[i] = selfReference(event)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// republishEvent responds to an event being published again with the same ID,
+// which succeeds as long as it is identical to the event already published.
+// Published events never change, so comparing against it is race-free
+func republishEvent(w http.ResponseWriter, r *http.Request, getEventByID storage.GetEvent, requested budevents.Event) {
+	existing, _, err := getEventByID(r.Context(), requested.EventID, nil)
+
+	if err != nil {
+		writeInternalError(w, err)
+		return
+	}
+
+	if !sameEvent(requested, *existing) {
+		writeConflict(w, requested.EventID)
+		return
+	}
+
+	writeReference(w, http.StatusOK, *existing)
+}
+
+func selfReference(event budevents.Event) budevents.Reference {
+	return budevents.Reference{
+		Href:     "/v1/events/" + event.EventID,
+		Type:     http.MethodGet,
+		Sequence: event.Sequence,
+	}
+}
+
+// republishEvents responds to a batch containing events that have already been
+// published, which only succeeds if the whole batch was published before
+func republishEvents(
+	w http.ResponseWriter,
+	r *http.Request,
+	getEventByID storage.GetEvent,
+	requested []budevents.Event,
+) {
+	existing := make([]budevents.Event, len(requested))
+
+	for i, event := range requested {
+		if event.EventID == "" {
+			writeProblem(w, http.StatusConflict, budevents.ProblemEventConflict, "part of the batch has already been published")
+			return
+		}
+
+		published, _, err := getEventByID(r.Context(), event.EventID, nil)
+
+		if errors.Is(err, storage.ErrEventNotFound) {
+			writeProblem(w, http.StatusConflict, budevents.ProblemEventConflict, "part of the batch has already been published")
+			return
+		}
+
+		if err != nil {
+			writeInternalError(w, err)
+			return
+		}
+
+		if !sameEvent(event, *published) {
+			writeConflict(w, event.EventID)
+			return
+		}
+
+		existing[i] = *published
+	}
+
+	writeBatch(w, http.StatusOK, existing)
+}
+
+// sameEvent compares a requested event with a published one. Only the fields
+// the sidecar fills in may be left out of the request, and the rest must
+// match exactly
+func sameEvent(requested budevents.Event, existing budevents.Event) bool {
+	if !requested.OccurredAt.IsZero() && !requested.OccurredAt.Equal(existing.OccurredAt) {
+		return false
+	}
+
+	if requested.Schema != "" && requested.Schema != existing.Schema {
+		return false
+	}
+
+	for _, field := range [][2]string{
+		{requested.EventName, existing.EventName},
+		{requested.CorrelationID, existing.CorrelationID},
+		{requested.CausationID, existing.CausationID},
+		{requested.Producer, existing.Producer},
+		{requested.Subject, existing.Subject},
+	} {
+		if field[0] != field[1] {
+			return false
+		}
+	}
+
+	if requested.SchemaVersion != 0 && requested.SchemaVersion != existing.SchemaVersion {
+		return false
+	}
+
+	return samePayload(requested.Payload, existing.Payload)
+}
+
+func samePayload(a json.RawMessage, b json.RawMessage) bool {
+	var decodedA, decodedB interface{}
+
+	if err := json.Unmarshal(a, &decodedA); err != nil {
+		return bytes.Equal(a, b)
+	}
+
+	if err := json.Unmarshal(b, &decodedB); err != nil {
+		return false
+	}
+
+	return reflect.DeepEqual(decodedA, decodedB)
+}
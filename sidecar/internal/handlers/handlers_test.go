@@ -0,0 +1,215 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"github.com/thisisbud/backend-events-sidecar/internal/sidecartest"
+	"github.com/thisisbud/backend-events-sidecar/pkg/budevents"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestPublishEventIfMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		ifMatch string
+		status  int
+		code    string
+	}{
+		{"latest event", `"seed"`, http.StatusCreated, ""},
+		{"weak latest event", `W/"seed"`, http.StatusCreated, ""},
+		{"unquoted latest event", `seed`, http.StatusCreated, ""},
+		{"another event", `"other"`, http.StatusPreconditionFailed, budevents.ProblemPreconditionFailed},
+		{"any event", `*`, http.StatusBadRequest, budevents.ProblemInvalidHeader},
+		{"list of events", `"seed", "other"`, http.StatusBadRequest, budevents.ProblemInvalidHeader},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sidecar := sidecartest.New(t)
+
+			if resp := post(t, sidecar.URL+"/v1/events", "", `{"event_id":"seed","event_name":"seeded","payload":{}}`); resp.StatusCode != http.StatusCreated {
+				t.Fatalf("seeding got status [%d]", resp.StatusCode)
+			}
+
+			resp := post(t, sidecar.URL+"/v1/events", test.ifMatch, `{"event_name":"followed","payload":{}}`)
+
+			if resp.StatusCode != test.status {
+				t.Fatalf("got status [%d], want [%d]", resp.StatusCode, test.status)
+			}
+
+			if test.code == "" {
+				return
+			}
+
+			var problem budevents.Problem
+
+			if err := json.NewDecoder(resp.Body).Decode(&problem); err != nil {
+				t.Fatal(err)
+			}
+
+			if problem.Code != test.code {
+				t.Errorf("got problem [%s], want [%s]", problem.Code, test.code)
+			}
+		})
+	}
+}
+
+func post(t *testing.T, url string, ifMatch string, body string) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestPublishEventAgain(t *testing.T) {
+	const published = `{"event_id":"e1","event_name":"loan_opened","occurred_at":"2023-01-02T03:04:05Z","payload":{"a":1},"subject":"loan-1","producer":"svc-a","correlation_id":"c1","causation_id":"x1"}`
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"identical", published, http.StatusOK},
+		{"without the fields the sidecar fills in", `{"event_id":"e1","event_name":"loan_opened","payload":{"a":1},"subject":"loan-1","producer":"svc-a","correlation_id":"c1","causation_id":"x1"}`, http.StatusOK},
+		{"payload written differently", `{"event_id":"e1","event_name":"loan_opened","payload":{ "a" : 1.0 },"subject":"loan-1","producer":"svc-a","correlation_id":"c1","causation_id":"x1"}`, http.StatusOK},
+		{"different payload", `{"event_id":"e1","event_name":"loan_opened","payload":{"a":2},"subject":"loan-1","producer":"svc-a","correlation_id":"c1","causation_id":"x1"}`, http.StatusConflict},
+		{"different event name", `{"event_id":"e1","event_name":"loan_closed","payload":{"a":1},"subject":"loan-1","producer":"svc-a","correlation_id":"c1","causation_id":"x1"}`, http.StatusConflict},
+		{"without subject", `{"event_id":"e1","event_name":"loan_opened","payload":{"a":1},"producer":"svc-a","correlation_id":"c1","causation_id":"x1"}`, http.StatusConflict},
+		{"without producer", `{"event_id":"e1","event_name":"loan_opened","payload":{"a":1},"subject":"loan-1","correlation_id":"c1","causation_id":"x1"}`, http.StatusConflict},
+		{"without correlation", `{"event_id":"e1","event_name":"loan_opened","payload":{"a":1},"subject":"loan-1","producer":"svc-a","causation_id":"x1"}`, http.StatusConflict},
+		{"without causation", `{"event_id":"e1","event_name":"loan_opened","payload":{"a":1},"subject":"loan-1","producer":"svc-a","correlation_id":"c1"}`, http.StatusConflict},
+		{"different occurred at", `{"event_id":"e1","event_name":"loan_opened","occurred_at":"2024-01-02T03:04:05Z","payload":{"a":1},"subject":"loan-1","producer":"svc-a","correlation_id":"c1","causation_id":"x1"}`, http.StatusConflict},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sidecar := sidecartest.New(t)
+
+			if resp := post(t, sidecar.URL+"/v1/events", "", published); resp.StatusCode != http.StatusCreated {
+				t.Fatalf("publishing got status [%d]", resp.StatusCode)
+			}
+
+			if resp := post(t, sidecar.URL+"/v1/events", "", test.body); resp.StatusCode != test.status {
+				t.Errorf("publishing again got status [%d], want [%d]", resp.StatusCode, test.status)
+			}
+		})
+	}
+}
+
+func TestPublishIsIdempotentByEventID(t *testing.T) {
+	const event = `{"event_id":"e1","event_name":"loan_opened","payload":{"a":1}}`
+
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		status int
+	}{
+		{"same event", "/v1/events", event, http.StatusOK},
+		{"same ID with a different body", "/v1/events", `{"event_id":"e1","event_name":"loan_opened","payload":{"a":2}}`, http.StatusConflict},
+		{"same event in a batch", "/v1/events:batch", `{"events":[` + event + `]}`, http.StatusOK},
+		{"batch with the event and a new one", "/v1/events:batch", `{"events":[` + event + `,{"event_id":"e2","event_name":"loan_opened","payload":{}}]}`, http.StatusConflict},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sidecar := sidecartest.New(t)
+
+			if resp := post(t, sidecar.URL+"/v1/events", "", event); resp.StatusCode != http.StatusCreated {
+				t.Fatalf("publishing got status [%d]", resp.StatusCode)
+			}
+
+			if resp := post(t, sidecar.URL+test.path, "", test.body); resp.StatusCode != test.status {
+				t.Errorf("publishing again got status [%d], want [%d]", resp.StatusCode, test.status)
+			}
+
+			if stream := sidecartest.Stream(t, sidecar.URL); len(stream) != 1 || stream[0].EventID != "e1" {
+				t.Errorf("stream holds %d events, want only the first", len(stream))
+			}
+		})
+	}
+}
+
+func TestPublishBatchIsAtomic(t *testing.T) {
+	tests := []struct {
+		name    string
+		ifMatch string
+		body    string
+		status  int
+	}{
+		{"new events", "", `{"events":[{"event_name":"a","payload":{}},{"event_name":"b","payload":{}},{"event_name":"c","payload":{}}]}`, http.StatusCreated},
+		{"after the expected event", `"seed"`, `{"events":[{"event_name":"a","payload":{}},{"event_name":"b","payload":{}},{"event_name":"c","payload":{}}]}`, http.StatusCreated},
+		{"an already published event", "", `{"events":[{"event_name":"a","payload":{}},{"event_id":"seed","event_name":"b","payload":{}}]}`, http.StatusConflict},
+		{"an event twice", "", `{"events":[{"event_id":"x","event_name":"a","payload":{}},{"event_id":"x","event_name":"a","payload":{}}]}`, http.StatusBadRequest},
+		{"an invalid event", "", `{"events":[{"event_name":"a","payload":{}},{"event_name":"Not Snake Case","payload":{}}]}`, http.StatusBadRequest},
+		{"a failed precondition", `"other"`, `{"events":[{"event_name":"a","payload":{}},{"event_name":"b","payload":{}}]}`, http.StatusPreconditionFailed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sidecar := sidecartest.New(t)
+
+			if resp := post(t, sidecar.URL+"/v1/events", "", `{"event_id":"seed","event_name":"seeded","payload":{}}`); resp.StatusCode != http.StatusCreated {
+				t.Fatalf("seeding got status [%d]", resp.StatusCode)
+			}
+
+			resp := post(t, sidecar.URL+"/v1/events:batch", test.ifMatch, test.body)
+
+			if resp.StatusCode != test.status {
+				t.Fatalf("got status [%d], want [%d]", resp.StatusCode, test.status)
+			}
+
+			stream := sidecartest.Stream(t, sidecar.URL)
+
+			if test.status != http.StatusCreated {
+				if len(stream) != 1 {
+					t.Errorf("stream holds [%d] events after a failed batch, want only the seed", len(stream))
+				}
+
+				return
+			}
+
+			var batch budevents.BatchResponse
+
+			if err := json.NewDecoder(resp.Body).Decode(&batch); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(stream) != 4 {
+				t.Fatalf("stream holds [%d] events, want the seed and the batch", len(stream))
+			}
+
+			// the batch follows the seed in order, without gaps
+			for i, event := range stream {
+				if event.Sequence != int64(i+1) {
+					t.Errorf("event [%d] has sequence [%d], want [%d]", i, event.Sequence, i+1)
+				}
+			}
+
+			for i, ref := range batch.Events {
+				if ref.Sequence != stream[i+1].Sequence || ref.Href != "/v1/events/"+stream[i+1].EventID {
+					t.Errorf("batch reference [%d] is %+v, want event [%s] at sequence [%d]", i, ref, stream[i+1].EventID, stream[i+1].Sequence)
+				}
+			}
+		})
+	}
+}
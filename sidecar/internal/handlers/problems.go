@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/thisisbud/backend-events-sidecar/pkg/budevents"
+	"log"
+	"net/http"
+)
+
+func writeProblem(w http.ResponseWriter, status int, code string, detail string, violations ...budevents.Violation) {
+	w.Header().Set("Content-Type", budevents.ProblemContentType)
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(budevents.Problem{
+		Title:      http.StatusText(status),
+		Status:     status,
+		Detail:     detail,
+		Code:       code,
+		Violations: violations,
+	})
+}
+
+func writeInternalError(w http.ResponseWriter, err error) {
+	log.Println(err)
+	writeProblem(w, http.StatusInternalServerError, budevents.ProblemInternalError, "")
+}
+
+func writeConflict(w http.ResponseWriter, eventID string) {
+	writeProblem(
+		w,
+		http.StatusConflict,
+		budevents.ProblemEventConflict,
+		fmt.Sprintf("a different event has already been published with ID [%s]", eventID),
+	)
+}
+
+func writePreconditionFailed(w http.ResponseWriter) {
+	writeProblem(
+		w,
+		http.StatusPreconditionFailed,
+		budevents.ProblemPreconditionFailed,
+		"the latest event is no longer the expected previous event",
+	)
+}
+
+// decodeBody decodes a JSON request body of at most maxBodyBytes, responding
+// with a problem if it cannot
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes)).Decode(v)
+
+	var tooLarge *http.MaxBytesError
+
+	if errors.As(err, &tooLarge) {
+		writeProblem(w, http.StatusRequestEntityTooLarge, budevents.ProblemBodyTooLarge, err.Error())
+		return false
+	}
+
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, budevents.ProblemInvalidBody, err.Error())
+		return false
+	}
+
+	return true
+}
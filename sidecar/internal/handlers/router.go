@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/thisisbud/backend-events-sidecar/internal/schema"
+	"github.com/thisisbud/backend-events-sidecar/internal/storage"
+	"net/http"
+)
+
+// Storage is the storage behind the sidecar's routes
+type Storage struct {
+	PublishEvent          storage.PublishEvent
+	PublishEvents         storage.PublishEvents
+	GetEvent              storage.GetEvent
+	GetLatestEvent        storage.GetLatestEvent
+	GetEventAt            storage.GetEventAt
+	GetSubjectEvent       storage.GetSubjectEvent
+	GetLatestSubjectEvent storage.GetLatestSubjectEvent
+}
+
+// NewRouter routes every endpoint of the sidecar, requiring new schema
+// versions to have at least the given compatibility
+func NewRouter(
+	store Storage,
+	schemas *schema.Registry,
+	compatibility schema.Compatibility,
+	newID func() string,
+) http.Handler {
+	r := chi.NewRouter()
+	r.Get("/", Wellknown)
+	r.Get("/v1/events", GetLatestEvent(store.GetLatestEvent, store.GetEventAt))
+	r.Get("/v1/events/{event_id}", GetEvent(store.GetEvent))
+	r.Get("/v1/subjects/{subject}/events", GetLatestSubjectEvent(store.GetLatestSubjectEvent))
+	r.Get("/v1/subjects/{subject}/events/{event_id}", GetSubjectEvent(store.GetSubjectEvent))
+	r.Post("/v1/events", PublishEvent(store.PublishEvent, store.GetEvent, schemas, newID))
+	r.Post("/v1/events:batch", PublishEvents(store.PublishEvents, store.GetEvent, schemas, newID))
+	r.Get("/v1/schemas", ListSchemas(schemas))
+	r.Get("/v1/schemas/{event_name}/{version}", GetSchema(schemas))
+	r.Post("/v1/schemas/{event_name}", RegisterSchema(schemas, compatibility))
+
+	return r
+}
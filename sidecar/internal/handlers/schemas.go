@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/thisisbud/backend-events-sidecar/internal/schema"
+	"github.com/thisisbud/backend-events-sidecar/pkg/budevents"
+	"net/http"
+	"strconv"
+)
+
+const schemaContentType = "application/schema+json"
+
+func ListSchemas(schemas *schema.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		refs := schemas.List()
+
+		if refs == nil {
+			refs = []budevents.SchemaReference{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(budevents.SchemaIndex{Schemas: refs})
+	}
+}
+
+func GetSchema(schemas *schema.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		version, err := strconv.Atoi(chi.URLParam(r, "version"))
+		payloadSchema, ok := schemas.Get(chi.URLParam(r, "event_name"), version)
+
+		if err != nil || !ok {
+			writeProblem(w, http.StatusNotFound, budevents.ProblemSchemaNotFound, "no schema found")
+			return
+		}
+
+		w.Header().Set("Content-Type", schemaContentType)
+		_ = json.NewEncoder(w).Encode(payloadSchema)
+	}
+}
+
+// RegisterSchema adds the requested schema as the next version for an event
+// name, checked against the latest version with the given compatibility. The
+// request can ask for a stricter one with ?compatibility=, but not a looser one
+func RegisterSchema(schemas *schema.Registry, compatibility schema.Compatibility) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		eventName := chi.URLParam(r, "event_name")
+
+		if !eventNamePattern.MatchString(eventName) {
+			writeProblem(w, http.StatusBadRequest, budevents.ProblemInvalidBody, "schemas are named after snake_case event names")
+			return
+		}
+
+		required := compatibility
+
+		if requested := r.URL.Query().Get("compatibility"); requested != "" {
+			var err error
+			required, err = schema.ParseCompatibility(requested)
+
+			if err != nil {
+				writeProblem(w, http.StatusBadRequest, budevents.ProblemInvalidQuery, err.Error())
+				return
+			}
+
+			if !required.Includes(compatibility) {
+				writeProblem(
+					w,
+					http.StatusBadRequest,
+					budevents.ProblemInvalidQuery,
+					fmt.Sprintf("compatibility [%s] is looser than the required [%s]", required, compatibility),
+				)
+				return
+			}
+		}
+
+		var blob json.RawMessage
+
+		if !decodeBody(w, r, &blob) {
+			return
+		}
+
+		payloadSchema, err := schema.Parse(blob)
+
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, budevents.ProblemInvalidSchema, err.Error())
+			return
+		}
+
+		version, created, err := schemas.Register(eventName, payloadSchema, required)
+
+		var incompatible *schema.IncompatibleError
+
+		if errors.As(err, &incompatible) {
+			writeProblem(w, http.StatusConflict, budevents.ProblemIncompatibleSchema, err.Error(), incompatible.Violations...)
+			return
+		}
+
+		if err != nil {
+			writeInternalError(w, err)
+			return
+		}
+
+		status := http.StatusOK
+
+		if created {
+			status = http.StatusCreated
+		}
+
+		w.Header().Set("Location", schema.URL(eventName, version))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(budevents.SchemaReference{
+			EventName: eventName,
+			Version:   version,
+			Href:      schema.URL(eventName, version),
+		})
+	}
+}
+
+// validatePayload checks the payload of an event against the schema it names,
+// or the version of its event name's schema it asks for, or else the latest,
+// returning the event with the schema and version it was checked against.
+// Events without a registered schema, or that name a schema held outside the
+// sidecar, are left as they are
+func validatePayload(schemas *schema.Registry, event budevents.Event, prefix string) (budevents.Event, []budevents.Violation) {
+	if event.Schema == "" {
+		latest, version, ok := schemas.Latest(event.EventName)
+
+		if !ok {
+			return event, nil
+		}
+
+		payloadSchema := latest
+
+		if event.SchemaVersion > 0 {
+			version = event.SchemaVersion
+			payloadSchema, ok = schemas.Get(event.EventName, version)
+
+			if !ok {
+				return event, []budevents.Violation{{
+					Field:   prefix + "schema_version",
+					Message: "is not a registered version of the schema for the event",
+				}}
+			}
+		}
+
+		event.Schema = schema.URL(event.EventName, version)
+		event.SchemaVersion = version
+		return event, payloadSchema.Validate(event.Payload, prefix+"payload")
+	}
+
+	eventName, version, ok := schema.ParseURL(event.Schema)
+
+	if !ok {
+		return event, nil
+	}
+
+	payloadSchema, ok := schemas.Get(eventName, version)
+
+	if !ok {
+		return event, []budevents.Violation{{
+			Field:   prefix + "schema",
+			Message: "is not a registered schema",
+		}}
+	}
+
+	if event.SchemaVersion > 0 && event.SchemaVersion != version {
+		return event, []budevents.Violation{{
+			Field:   prefix + "schema_version",
+			Message: "must match the version of the schema",
+		}}
+	}
+
+	event.SchemaVersion = version
+	return event, payloadSchema.Validate(event.Payload, prefix+"payload")
+}
@@ -0,0 +1,89 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"github.com/thisisbud/backend-events-sidecar/internal/handlers"
+	"github.com/thisisbud/backend-events-sidecar/internal/schema"
+	"github.com/thisisbud/backend-events-sidecar/pkg/budevents"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegisterSchema(t *testing.T) {
+	const (
+		v1           = `{"type":"object","properties":{"id":{"type":"string"}}}`
+		breaking     = `{"type":"object","properties":{"id":{"type":"integer"}}}`
+		optionalProp = `{"type":"object","properties":{"id":{"type":"string"},"note":{"type":"string"}}}`
+	)
+
+	tests := []struct {
+		name          string
+		registered    bool
+		compatibility schema.Compatibility
+		path          string
+		body          string
+		status        int
+		code          string
+	}{
+		{"first version", false, schema.CompatibilityBackward, "/v1/schemas/order_placed", v1, http.StatusCreated, ""},
+		{"compatible version", true, schema.CompatibilityBackward, "/v1/schemas/order_placed", optionalProp, http.StatusCreated, ""},
+		{"incompatible version", true, schema.CompatibilityBackward, "/v1/schemas/order_placed", breaking, http.StatusConflict, budevents.ProblemIncompatibleSchema},
+		{"looser compatibility", true, schema.CompatibilityBackward, "/v1/schemas/order_placed?compatibility=none", breaking, http.StatusBadRequest, budevents.ProblemInvalidQuery},
+		{"stricter compatibility", true, schema.CompatibilityBackward, "/v1/schemas/order_placed?compatibility=full", optionalProp, http.StatusCreated, ""},
+		{"loose configured compatibility", true, schema.CompatibilityNone, "/v1/schemas/order_placed", breaking, http.StatusCreated, ""},
+		{"unknown compatibility", true, schema.CompatibilityBackward, "/v1/schemas/order_placed?compatibility=sideways", v1, http.StatusBadRequest, budevents.ProblemInvalidQuery},
+		{"badly formatted event name", true, schema.CompatibilityBackward, "/v1/schemas/Order-Placed", v1, http.StatusBadRequest, budevents.ProblemInvalidBody},
+		{"looping refs", true, schema.CompatibilityBackward, "/v1/schemas/boom", `{"$defs":{"a":{"$ref":"#/$defs/a"}},"$ref":"#/$defs/a"}`, http.StatusBadRequest, budevents.ProblemInvalidSchema},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			schemas := schema.NewRegistry()
+
+			if test.registered {
+				if _, _, err := schemas.Register("order_placed", mustParse(t, v1), schema.CompatibilityNone); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			r := chi.NewRouter()
+			r.Post("/v1/schemas/{event_name}", handlers.RegisterSchema(schemas, test.compatibility))
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, test.path, strings.NewReader(test.body)))
+
+			if w.Code != test.status {
+				t.Fatalf("got status [%d], want [%d]: %s", w.Code, test.status, w.Body)
+			}
+
+			if test.code == "" {
+				return
+			}
+
+			var problem budevents.Problem
+
+			if err := json.NewDecoder(w.Body).Decode(&problem); err != nil {
+				t.Fatal(err)
+			}
+
+			if problem.Code != test.code {
+				t.Errorf("got problem [%s], want [%s]", problem.Code, test.code)
+			}
+		})
+	}
+}
+
+func mustParse(t *testing.T, blob string) *schema.Schema {
+	t.Helper()
+
+	s, err := schema.Parse([]byte(blob))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/thisisbud/backend-events-sidecar/pkg/budevents"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	maxBodyBytes     = 1 << 20
+	maxEventIDLength = 255
+	// maxClockSkew is how far in the future an event is allowed to have
+	// occurred, to allow for producers whose clocks run ahead
+	maxClockSkew = time.Hour
+)
+
+var eventNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,127}$`)
+
+// validateEvent returns what is wrong with a requested event, with fields
+// prefixed by where the event appears in the request
+func validateEvent(event budevents.Event, prefix string) []budevents.Violation {
+	var violations []budevents.Violation
+
+	violate := func(field string, message string) {
+		violations = append(violations, budevents.Violation{
+			Field:   prefix + field,
+			Message: message,
+		})
+	}
+
+	if len(event.EventID) > maxEventIDLength || strings.ContainsAny(event.EventID, "/?#") {
+		violate("event_id", fmt.Sprintf("must be at most %d characters, without / ? or #", maxEventIDLength))
+	}
+
+	if event.EventName == "" {
+		violate("event_name", "is required")
+	} else if !eventNamePattern.MatchString(event.EventName) {
+		violate("event_name", "must be snake_case, starting with a letter and at most 128 characters")
+	}
+
+	if payload := bytes.TrimSpace(event.Payload); len(payload) == 0 || payload[0] != '{' {
+		violate("payload", "must be a JSON object")
+	}
+
+	if event.Schema != "" && !validSchemaURL(event.Schema) {
+		violate("schema", "must be an absolute URL or path")
+	}
+
+	if len(event.Subject) > maxEventIDLength || strings.ContainsAny(event.Subject, "/?#") {
+		violate("subject", fmt.Sprintf("must be at most %d characters, without / ? or #", maxEventIDLength))
+	}
+
+	for _, field := range [][2]string{
+		{"correlation_id", event.CorrelationID},
+		{"causation_id", event.CausationID},
+		{"producer", event.Producer},
+	} {
+		if len(field[1]) > maxEventIDLength {
+			violate(field[0], fmt.Sprintf("must be at most %d characters", maxEventIDLength))
+		}
+	}
+
+	if event.SchemaVersion < 0 {
+		violate("schema_version", "must not be negative")
+	}
+
+	if event.OccurredAt.After(time.Now().Add(maxClockSkew)) {
+		violate("occurred_at", fmt.Sprintf("must not be more than %s in the future", maxClockSkew))
+	}
+
+	return violations
+}
+
+// validSchemaURL accepts absolute URLs and paths relative to the sidecar
+func validSchemaURL(schema string) bool {
+	u, err := url.Parse(schema)
+
+	if err != nil {
+		return false
+	}
+
+	return u.IsAbs() || strings.HasPrefix(u.Path, "/")
+}
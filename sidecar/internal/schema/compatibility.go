@@ -0,0 +1,317 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/thisisbud/backend-events-sidecar/pkg/budevents"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Compatibility is how a new version of a schema must relate to the latest
+// version before it can be registered
+type Compatibility string
+
+const (
+	// CompatibilityNone registers any new version
+	CompatibilityNone Compatibility = "none"
+	// CompatibilityBackward requires consumers of the new version to be able
+	// to read payloads written against the latest version
+	CompatibilityBackward Compatibility = "backward"
+	// CompatibilityForward requires consumers of the latest version to be able
+	// to read payloads written against the new version
+	CompatibilityForward Compatibility = "forward"
+	// CompatibilityFull is both backward and forward compatibility
+	CompatibilityFull Compatibility = "full"
+)
+
+func ParseCompatibility(s string) (Compatibility, error) {
+	switch compatibility := Compatibility(s); compatibility {
+	case CompatibilityNone, CompatibilityBackward, CompatibilityForward, CompatibilityFull:
+		return compatibility, nil
+	default:
+		return "", fmt.Errorf("unknown compatibility [%s]", s)
+	}
+}
+
+// Includes reports whether a schema with the compatibility is also compatible
+// in the way other requires
+func (compatibility Compatibility) Includes(other Compatibility) bool {
+	return compatibility == other || compatibility == CompatibilityFull || other == CompatibilityNone
+}
+
+// IncompatibleError is returned when registering a version that breaks the
+// required compatibility, with where the versions differ
+type IncompatibleError struct {
+	Compatibility Compatibility
+	Violations    []budevents.Violation
+}
+
+func (err *IncompatibleError) Error() string {
+	return fmt.Sprintf("schema is not %s compatible with the latest version", err.Compatibility)
+}
+
+// CheckCompatibility returns where next breaks compatibility with previous.
+// Payloads are assumed to only contain the properties their schema declares,
+// so adding an optional property is compatible in both directions
+func CheckCompatibility(compatibility Compatibility, previous *Schema, next *Schema) []budevents.Violation {
+	var violations []budevents.Violation
+
+	if compatibility == CompatibilityBackward || compatibility == CompatibilityFull {
+		c := checker{reader: "new", readerRoot: next, writerRoot: previous, seen: map[[2]*Schema]bool{}}
+		c.check(next, previous, "payload")
+		violations = append(violations, c.violations...)
+	}
+
+	if compatibility == CompatibilityForward || compatibility == CompatibilityFull {
+		c := checker{reader: "old", readerRoot: previous, writerRoot: next, seen: map[[2]*Schema]bool{}}
+		c.check(previous, next, "payload")
+		violations = append(violations, c.violations...)
+	}
+
+	return violations
+}
+
+// checker finds payloads that are valid against the writer's schema but
+// would be rejected by the reader's. Where it cannot tell, it assumes they
+// would be
+type checker struct {
+	reader     string
+	readerRoot *Schema
+	writerRoot *Schema
+	seen       map[[2]*Schema]bool
+	violations []budevents.Violation
+}
+
+func (c *checker) violate(field string, message string, args ...interface{}) {
+	c.violations = append(c.violations, budevents.Violation{
+		Field:   field,
+		Message: fmt.Sprintf("the %s schema %s", c.reader, fmt.Sprintf(message, args...)),
+	})
+}
+
+func (c *checker) check(reader *Schema, writer *Schema, field string) {
+	// schemas are compared by what their refs resolve to, ignoring keywords
+	// beside a $ref, and recursive ones are only compared once
+	reader, writer = resolve(c.readerRoot, reader), resolve(c.writerRoot, writer)
+
+	if c.seen[[2]*Schema{reader, writer}] {
+		return
+	}
+
+	c.seen[[2]*Schema{reader, writer}] = true
+
+	if writer.boolean != nil && !*writer.boolean {
+		return
+	}
+
+	if reader.boolean != nil {
+		if !*reader.boolean {
+			c.violate(field, "does not allow it")
+		}
+
+		return
+	}
+
+	if len(reader.AllOf)+len(reader.AnyOf)+len(reader.OneOf) > 0 || reader.Not != nil ||
+		len(writer.AllOf)+len(writer.AnyOf)+len(writer.OneOf) > 0 || writer.Not != nil {
+		if !sameCombinators(reader, writer) {
+			c.violate(field, "changes allOf, anyOf, oneOf or not, which cannot be compared")
+		}
+	}
+
+	c.checkType(reader, writer, field)
+	c.checkValues(reader, writer, field)
+	c.checkBounds(reader, writer, field)
+	c.checkObject(reader, writer, field)
+	c.checkArray(reader, writer, field)
+}
+
+// resolve follows $refs until it reaches a schema without one, which Parse
+// guarantees happens
+func resolve(root *Schema, schema *Schema) *Schema {
+	for schema.Ref != "" {
+		schema, _ = root.Resolve(schema.Ref)
+	}
+
+	return schema
+}
+
+func (c *checker) checkType(reader *Schema, writer *Schema, field string) {
+	if len(reader.Type) == 0 {
+		return
+	}
+
+	if len(writer.Type) == 0 {
+		c.violate(field, "only accepts type %s", strings.Join(reader.Type, " or "))
+		return
+	}
+
+	for _, name := range writer.Type {
+		if !reader.Type.includes(name) {
+			c.violate(field, "does not accept type %s", name)
+		}
+	}
+}
+
+func (t types) includes(name string) bool {
+	for _, candidate := range t {
+		if candidate == name || (candidate == "number" && name == "integer") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *checker) checkValues(reader *Schema, writer *Schema, field string) {
+	var written []interface{}
+
+	switch {
+	case writer.Const != nil:
+		written = []interface{}{*writer.Const}
+	case len(writer.Enum) > 0:
+		written = writer.Enum
+	}
+
+	if len(reader.Enum) > 0 {
+		if written == nil {
+			c.violate(field, "only accepts %s", enumList(reader.Enum))
+		}
+
+		for _, value := range written {
+			if !contains(reader.Enum, value) {
+				c.violate(field, "does not accept %s", enumList([]interface{}{value}))
+			}
+		}
+	}
+
+	if reader.Const != nil {
+		if written == nil {
+			c.violate(field, "only accepts %s", enumList([]interface{}{*reader.Const}))
+		}
+
+		for _, value := range written {
+			if !equal(*reader.Const, value) {
+				c.violate(field, "does not accept %s", enumList([]interface{}{value}))
+			}
+		}
+	}
+}
+
+func (c *checker) checkBounds(reader *Schema, writer *Schema, field string) {
+	lower := func(name string, r *float64, w *float64) {
+		if r != nil && (w == nil || *w < *r) {
+			c.violate(field, "has a higher %s of %v", name, *r)
+		}
+	}
+
+	upper := func(name string, r *float64, w *float64) {
+		if r != nil && (w == nil || *w > *r) {
+			c.violate(field, "has a lower %s of %v", name, *r)
+		}
+	}
+
+	lower("minimum", reader.Minimum, writer.Minimum)
+	lower("exclusiveMinimum", reader.ExclusiveMinimum, writer.ExclusiveMinimum)
+	upper("maximum", reader.Maximum, writer.Maximum)
+	upper("exclusiveMaximum", reader.ExclusiveMaximum, writer.ExclusiveMaximum)
+	lower("minLength", toFloat(reader.MinLength), toFloat(writer.MinLength))
+	upper("maxLength", toFloat(reader.MaxLength), toFloat(writer.MaxLength))
+	lower("minItems", toFloat(reader.MinItems), toFloat(writer.MinItems))
+	upper("maxItems", toFloat(reader.MaxItems), toFloat(writer.MaxItems))
+
+	if reader.Pattern != "" && reader.Pattern != writer.Pattern {
+		c.violate(field, "requires the pattern [%s]", reader.Pattern)
+	}
+
+	if reader.Format != "" && reader.Format != writer.Format {
+		c.violate(field, "requires the format %s", reader.Format)
+	}
+}
+
+func (c *checker) checkObject(reader *Schema, writer *Schema, field string) {
+	for _, name := range reader.Required {
+		if !writer.requires(name) {
+			c.violate(join(field, name), "requires it")
+		}
+	}
+
+	names := make([]string, 0, len(writer.Properties))
+
+	for name := range writer.Properties {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if property, ok := reader.Properties[name]; ok {
+			c.check(property, writer.Properties[name], join(field, name))
+		} else if reader.AdditionalProperties != nil {
+			c.check(reader.AdditionalProperties, writer.Properties[name], join(field, name))
+		}
+	}
+
+	if reader.AdditionalProperties != nil && writer.AdditionalProperties != nil {
+		c.check(reader.AdditionalProperties, writer.AdditionalProperties, field)
+	}
+}
+
+func (schema *Schema) requires(name string) bool {
+	for _, required := range schema.Required {
+		if required == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *checker) checkArray(reader *Schema, writer *Schema, field string) {
+	if reader.Items == nil {
+		return
+	}
+
+	if writer.Items == nil {
+		c.violate(field+"[]", "constrains the items")
+		return
+	}
+
+	c.check(reader.Items, writer.Items, field+"[]")
+}
+
+func sameCombinators(a *Schema, b *Schema) bool {
+	return sameJSON(a.AllOf, b.AllOf) &&
+		sameJSON(a.AnyOf, b.AnyOf) &&
+		sameJSON(a.OneOf, b.OneOf) &&
+		sameJSON(a.Not, b.Not)
+}
+
+// sameJSON compares schemas by the JSON they serialise to, since compiled
+// patterns are never deeply equal
+func sameJSON(a interface{}, b interface{}) bool {
+	var decodedA, decodedB interface{}
+
+	if !roundTrip(a, &decodedA) || !roundTrip(b, &decodedB) {
+		return false
+	}
+
+	return reflect.DeepEqual(decodedA, decodedB)
+}
+
+func roundTrip(value interface{}, decoded *interface{}) bool {
+	blob, err := json.Marshal(value)
+
+	return err == nil && json.Unmarshal(blob, decoded) == nil
+}
+
+func toFloat(n *int) *float64 {
+	if n == nil {
+		return nil
+	}
+
+	f := float64(*n)
+	return &f
+}
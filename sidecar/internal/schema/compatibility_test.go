@@ -0,0 +1,152 @@
+package schema_test
+
+import (
+	"github.com/thisisbud/backend-events-sidecar/internal/schema"
+	"testing"
+)
+
+func TestCheckCompatibility(t *testing.T) {
+	const (
+		withRef   = `{"type":"object","required":["id"],"properties":{"id":{"$ref":"#/$defs/id"}},"$defs":{"id":{"type":"string","minLength":1}}}`
+		recursive = `{"$defs":{"node":{"type":"object","properties":{"children":{"type":"array","items":{"$ref":"#/$defs/node"}}}}},"$ref":"#/$defs/node"}`
+	)
+
+	const base = `{"type":"object","required":["id"],"properties":{"id":{"type":"string"},"status":{"enum":["open","closed"]},"amount":{"type":"number","minimum":0}}}`
+
+	tests := []struct {
+		name     string
+		previous string
+		next     string
+		backward bool
+		forward  bool
+	}{
+		{"unchanged", base, base, true, true},
+		{
+			"optional property added",
+			base,
+			`{"type":"object","required":["id"],"properties":{"id":{"type":"string"},"status":{"enum":["open","closed"]},"amount":{"type":"number","minimum":0},"note":{"type":"string"}}}`,
+			true, true,
+		},
+		{
+			"required property added",
+			base,
+			`{"type":"object","required":["id","note"],"properties":{"id":{"type":"string"},"status":{"enum":["open","closed"]},"amount":{"type":"number","minimum":0},"note":{"type":"string"}}}`,
+			false, true,
+		},
+		{
+			"property no longer required",
+			base,
+			`{"type":"object","properties":{"id":{"type":"string"},"status":{"enum":["open","closed"]},"amount":{"type":"number","minimum":0}}}`,
+			true, false,
+		},
+		{
+			"type changed",
+			base,
+			`{"type":"object","required":["id"],"properties":{"id":{"type":"integer"},"status":{"enum":["open","closed"]},"amount":{"type":"number","minimum":0}}}`,
+			false, false,
+		},
+		{
+			"type made nullable",
+			base,
+			`{"type":"object","required":["id"],"properties":{"id":{"type":["string","null"]},"status":{"enum":["open","closed"]},"amount":{"type":"number","minimum":0}}}`,
+			true, false,
+		},
+		{
+			"enum narrowed",
+			base,
+			`{"type":"object","required":["id"],"properties":{"id":{"type":"string"},"status":{"enum":["open"]},"amount":{"type":"number","minimum":0}}}`,
+			false, true,
+		},
+		{
+			"enum widened",
+			base,
+			`{"type":"object","required":["id"],"properties":{"id":{"type":"string"},"status":{"enum":["open","closed","void"]},"amount":{"type":"number","minimum":0}}}`,
+			true, false,
+		},
+		{
+			"minimum raised",
+			base,
+			`{"type":"object","required":["id"],"properties":{"id":{"type":"string"},"status":{"enum":["open","closed"]},"amount":{"type":"number","minimum":1}}}`,
+			false, true,
+		},
+		{
+			"integer narrowed from number",
+			base,
+			`{"type":"object","required":["id"],"properties":{"id":{"type":"string"},"status":{"enum":["open","closed"]},"amount":{"type":"integer","minimum":0}}}`,
+			false, true,
+		},
+		{"unchanged with a ref", withRef, withRef, true, true},
+		{
+			"optional property added beside a ref",
+			withRef,
+			`{"type":"object","required":["id"],"properties":{"id":{"$ref":"#/$defs/id"},"note":{"type":"string"}},"$defs":{"id":{"type":"string","minLength":1}}}`,
+			true, true,
+		},
+		{
+			"ref target changed",
+			withRef,
+			`{"type":"object","required":["id"],"properties":{"id":{"$ref":"#/$defs/id"}},"$defs":{"id":{"type":"integer"}}}`,
+			false, false,
+		},
+		{
+			"ref inlined",
+			withRef,
+			`{"type":"object","required":["id"],"properties":{"id":{"type":"string","minLength":1}}}`,
+			true, true,
+		},
+		{"unchanged recursive ref", recursive, recursive, true, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			previous, next := mustParse(t, test.previous), mustParse(t, test.next)
+
+			for compatibility, want := range map[schema.Compatibility]bool{
+				schema.CompatibilityNone:     true,
+				schema.CompatibilityBackward: test.backward,
+				schema.CompatibilityForward:  test.forward,
+				schema.CompatibilityFull:     test.backward && test.forward,
+			} {
+				violations := schema.CheckCompatibility(compatibility, previous, next)
+
+				if compatible := len(violations) == 0; compatible != want {
+					t.Errorf("%s compatibility gave %v, want compatible [%t]", compatibility, violations, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCompatibilityIncludes(t *testing.T) {
+	tests := []struct {
+		compatibility schema.Compatibility
+		other         schema.Compatibility
+		includes      bool
+	}{
+		{schema.CompatibilityFull, schema.CompatibilityBackward, true},
+		{schema.CompatibilityFull, schema.CompatibilityForward, true},
+		{schema.CompatibilityBackward, schema.CompatibilityBackward, true},
+		{schema.CompatibilityBackward, schema.CompatibilityNone, true},
+		{schema.CompatibilityBackward, schema.CompatibilityForward, false},
+		{schema.CompatibilityBackward, schema.CompatibilityFull, false},
+		{schema.CompatibilityNone, schema.CompatibilityBackward, false},
+	}
+
+	for _, test := range tests {
+		if includes := test.compatibility.Includes(test.other); includes != test.includes {
+			t.Errorf("%s includes %s is [%t], want [%t]", test.compatibility, test.other, includes, test.includes)
+		}
+	}
+}
+
+func mustParse(t *testing.T, blob string) *schema.Schema {
+	t.Helper()
+
+	s, err := schema.Parse([]byte(blob))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
@@ -0,0 +1,217 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/thisisbud/backend-events-sidecar/pkg/budevents"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var urlPattern = regexp.MustCompile(`^/v1/schemas/([a-z][a-z0-9_]*)/([1-9][0-9]*)$`)
+
+// URL is where the sidecar serves a version of the schema for an event name
+func URL(eventName string, version int) string {
+	return fmt.Sprintf("/v1/schemas/%s/%d", eventName, version)
+}
+
+// ParseURL finds the event name and version of a schema served by the
+// sidecar, returning false for schemas held anywhere else
+func ParseURL(schemaURL string) (string, int, bool) {
+	match := urlPattern.FindStringSubmatch(schemaURL)
+
+	if match == nil {
+		return "", 0, false
+	}
+
+	version, err := strconv.Atoi(match[2])
+
+	if err != nil {
+		return "", 0, false
+	}
+
+	return match[1], version, true
+}
+
+// Registry holds the versions of the schema for each event name. A registry
+// loaded from a directory also saves the versions registered with it there
+type Registry struct {
+	mu      *sync.RWMutex
+	dir     string
+	schemas map[string]map[int]*Schema
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		mu:      new(sync.RWMutex),
+		schemas: map[string]map[int]*Schema{},
+	}
+}
+
+// LoadDir reads schemas laid out as <dir>/<event_name>/<version>.json, where
+// versions are whole numbers counting up from 1
+func LoadDir(dir string) (*Registry, error) {
+	registry := NewRegistry()
+	registry.dir = dir
+	files, err := filepath.Glob(filepath.Join(dir, "*", "*.json"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, filename := range files {
+		eventName := filepath.Base(filepath.Dir(filename))
+		version, err := strconv.Atoi(strings.TrimSuffix(filepath.Base(filename), ".json"))
+
+		if err != nil || version < 1 {
+			return nil, fmt.Errorf("schema [%s] must be named after its version, e.g. 1.json", filename)
+		}
+
+		if _, _, ok := ParseURL(URL(eventName, version)); !ok {
+			return nil, fmt.Errorf("schema [%s] must be in a directory named after its event", filename)
+		}
+
+		blob, err := os.ReadFile(filename)
+
+		if err != nil {
+			return nil, err
+		}
+
+		schema, err := Parse(blob)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid schema [%s]: %w", filename, err)
+		}
+
+		registry.add(eventName, version, schema)
+	}
+
+	return registry, nil
+}
+
+// add stores a version, with the registry locked by the caller unless it is
+// still being loaded
+func (registry *Registry) add(eventName string, version int, schema *Schema) {
+	if registry.schemas[eventName] == nil {
+		registry.schemas[eventName] = map[int]*Schema{}
+	}
+
+	registry.schemas[eventName][version] = schema
+}
+
+// Register adds a schema as the next version for an event name, as long as it
+// has the given compatibility with the latest version. Registering the latest
+// version again returns it rather than adding another, reporting false
+func (registry *Registry) Register(eventName string, schema *Schema, compatibility Compatibility) (int, bool, error) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	latest := registry.latestVersion(eventName)
+
+	if latest > 0 {
+		previous := registry.schemas[eventName][latest]
+
+		if sameJSON(previous, schema) {
+			return latest, false, nil
+		}
+
+		if violations := CheckCompatibility(compatibility, previous, schema); len(violations) > 0 {
+			return 0, false, &IncompatibleError{
+				Compatibility: compatibility,
+				Violations:    violations,
+			}
+		}
+	}
+
+	version := latest + 1
+
+	if registry.dir != "" {
+		if err := registry.save(eventName, version, schema); err != nil {
+			return 0, false, err
+		}
+	}
+
+	registry.add(eventName, version, schema)
+	return version, true, nil
+}
+
+func (registry *Registry) save(eventName string, version int, schema *Schema) error {
+	blob, err := json.Marshal(schema)
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Join(registry.dir, eventName), 0o755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(registry.dir, eventName, fmt.Sprintf("%d.json", version)), blob, 0o644)
+}
+
+// List returns each registered version, ordered by event name then version
+func (registry *Registry) List() []budevents.SchemaReference {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	var refs []budevents.SchemaReference
+
+	for eventName, versions := range registry.schemas {
+		for version := range versions {
+			refs = append(refs, budevents.SchemaReference{
+				EventName: eventName,
+				Version:   version,
+				Href:      URL(eventName, version),
+			})
+		}
+	}
+
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].EventName != refs[j].EventName {
+			return refs[i].EventName < refs[j].EventName
+		}
+
+		return refs[i].Version < refs[j].Version
+	})
+
+	return refs
+}
+
+func (registry *Registry) Get(eventName string, version int) (*Schema, bool) {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	schema, ok := registry.schemas[eventName][version]
+	return schema, ok
+}
+
+// Latest returns the highest version of the schema for an event name
+func (registry *Registry) Latest(eventName string) (*Schema, int, bool) {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	latest := registry.latestVersion(eventName)
+
+	if latest == 0 {
+		return nil, 0, false
+	}
+
+	return registry.schemas[eventName][latest], latest, true
+}
+
+func (registry *Registry) latestVersion(eventName string) int {
+	latest := 0
+
+	for version := range registry.schemas[eventName] {
+		if version > latest {
+			latest = version
+		}
+	}
+
+	return latest
+}
@@ -0,0 +1,612 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/thisisbud/backend-events-sidecar/pkg/budevents"
+	"math"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Schema is a JSON Schema, supporting the keywords needed to describe event
+// payloads: types, object properties, arrays, enums, numeric and string
+// bounds, patterns, common formats, combinators and local $refs. Unknown
+// keywords are ignored
+type Schema struct {
+	Description          string             `json:"description,omitempty"`
+	Type                 types              `json:"type,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Const                *interface{}       `json:"const,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	ExclusiveMinimum     *float64           `json:"exclusiveMinimum,omitempty"`
+	ExclusiveMaximum     *float64           `json:"exclusiveMaximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	AllOf                []*Schema          `json:"allOf,omitempty"`
+	AnyOf                []*Schema          `json:"anyOf,omitempty"`
+	OneOf                []*Schema          `json:"oneOf,omitempty"`
+	Not                  *Schema            `json:"not,omitempty"`
+	Ref                  string             `json:"$ref,omitempty"`
+	Defs                 map[string]*Schema `json:"$defs,omitempty"`
+	Definitions          map[string]*Schema `json:"definitions,omitempty"`
+
+	// boolean is set for the schemas true and false, which accept or reject
+	// any value
+	boolean *bool
+	pattern *regexp.Regexp
+	raw     json.RawMessage
+}
+
+// types is the type keyword, which can be a single type or a list of them
+type types []string
+
+func (t *types) UnmarshalJSON(blob []byte) error {
+	var single string
+
+	if err := json.Unmarshal(blob, &single); err == nil {
+		*t = types{single}
+		return nil
+	}
+
+	var list []string
+
+	if err := json.Unmarshal(blob, &list); err != nil {
+		return fmt.Errorf("type must be a string or an array of strings")
+	}
+
+	*t = list
+	return nil
+}
+
+func (t types) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+
+	return json.Marshal([]string(t))
+}
+
+// maxDepth bounds how deeply subschemas are followed while validating, as a
+// backstop against exhausting the stack
+const maxDepth = 256
+
+// Parse reads a schema, checking that its patterns compile and its $refs
+// point within the schema without looping back on themselves
+func Parse(blob []byte) (*Schema, error) {
+	var schema Schema
+
+	decoder := json.NewDecoder(bytes.NewReader(blob))
+	decoder.UseNumber()
+
+	if err := decoder.Decode(&schema); err != nil {
+		return nil, err
+	}
+
+	if err := schema.compile(&schema); err != nil {
+		return nil, err
+	}
+
+	if err := schema.checkCycles(&schema, map[*Schema]int{}); err != nil {
+		return nil, err
+	}
+
+	schema.raw = append(json.RawMessage(nil), blob...)
+	return &schema, nil
+}
+
+func (schema *Schema) UnmarshalJSON(blob []byte) error {
+	var boolean bool
+
+	if err := json.Unmarshal(blob, &boolean); err == nil {
+		schema.boolean = &boolean
+		return nil
+	}
+
+	// the alias drops this method, to decode the keywords as usual
+	type keywords Schema
+	return json.Unmarshal(blob, (*keywords)(schema))
+}
+
+func (schema *Schema) MarshalJSON() ([]byte, error) {
+	if schema.raw != nil {
+		return schema.raw, nil
+	}
+
+	if schema.boolean != nil {
+		return json.Marshal(*schema.boolean)
+	}
+
+	type keywords Schema
+	return json.Marshal((*keywords)(schema))
+}
+
+func (schema *Schema) compile(root *Schema) error {
+	if schema.Pattern != "" {
+		pattern, err := regexp.Compile(schema.Pattern)
+
+		if err != nil {
+			return fmt.Errorf("invalid pattern [%s]: %w", schema.Pattern, err)
+		}
+
+		schema.pattern = pattern
+	}
+
+	if schema.Ref != "" {
+		if _, err := root.Resolve(schema.Ref); err != nil {
+			return err
+		}
+	}
+
+	for _, child := range schema.children() {
+		if err := child.compile(root); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkCycles rejects $refs that lead back to a schema without going into a
+// property or item of the value first, since validating against them would
+// never end
+func (schema *Schema) checkCycles(root *Schema, marks map[*Schema]int) error {
+	if err := schema.followInPlace(root, marks); err != nil {
+		return err
+	}
+
+	for _, child := range schema.children() {
+		if err := child.checkCycles(root, marks); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// followInPlace follows the subschemas applied to the same value as the
+// schema, marking schemas 1 while they are being followed and 2 once they are
+// known not to lead back to themselves
+func (schema *Schema) followInPlace(root *Schema, marks map[*Schema]int) error {
+	switch marks[schema] {
+	case 1:
+		return fmt.Errorf("$refs must not loop back to a schema without going into a property or item")
+	case 2:
+		return nil
+	}
+
+	marks[schema] = 1
+
+	for _, next := range schema.inPlace(root) {
+		if err := next.followInPlace(root, marks); err != nil {
+			return err
+		}
+	}
+
+	marks[schema] = 2
+	return nil
+}
+
+// inPlace lists the subschemas that apply to the same value as the schema
+func (schema *Schema) inPlace(root *Schema) []*Schema {
+	var applied []*Schema
+
+	if schema.Ref != "" {
+		// refs were checked when the schema was compiled
+		ref, _ := root.Resolve(schema.Ref)
+		applied = append(applied, ref)
+	}
+
+	applied = append(applied, schema.AllOf...)
+	applied = append(applied, schema.AnyOf...)
+	applied = append(applied, schema.OneOf...)
+
+	if schema.Not != nil {
+		applied = append(applied, schema.Not)
+	}
+
+	return applied
+}
+
+func (schema *Schema) children() []*Schema {
+	var children []*Schema
+
+	for _, child := range schema.Properties {
+		children = append(children, child)
+	}
+
+	for _, child := range schema.Defs {
+		children = append(children, child)
+	}
+
+	for _, child := range schema.Definitions {
+		children = append(children, child)
+	}
+
+	children = append(children, schema.AllOf...)
+	children = append(children, schema.AnyOf...)
+	children = append(children, schema.OneOf...)
+
+	for _, child := range []*Schema{schema.AdditionalProperties, schema.Items, schema.Not} {
+		if child != nil {
+			children = append(children, child)
+		}
+	}
+
+	return children
+}
+
+// Resolve finds the schema a local $ref points to, either the root schema or
+// one of its definitions
+func (schema *Schema) Resolve(ref string) (*Schema, error) {
+	if ref == "#" {
+		return schema, nil
+	}
+
+	var defs map[string]*Schema
+	var name string
+
+	switch {
+	case strings.HasPrefix(ref, "#/$defs/"):
+		defs, name = schema.Defs, strings.TrimPrefix(ref, "#/$defs/")
+	case strings.HasPrefix(ref, "#/definitions/"):
+		defs, name = schema.Definitions, strings.TrimPrefix(ref, "#/definitions/")
+	default:
+		return nil, fmt.Errorf("unsupported $ref [%s]: only local definitions are supported", ref)
+	}
+
+	def, ok := defs[name]
+
+	if !ok {
+		return nil, fmt.Errorf("unknown $ref [%s]", ref)
+	}
+
+	return def, nil
+}
+
+// Validate returns where a JSON document does not match the schema, with
+// fields named from root
+func (schema *Schema) Validate(document json.RawMessage, root string) []budevents.Violation {
+	var value interface{}
+
+	decoder := json.NewDecoder(bytes.NewReader(document))
+	decoder.UseNumber()
+
+	if err := decoder.Decode(&value); err != nil {
+		return []budevents.Violation{{Field: root, Message: "must be valid JSON"}}
+	}
+
+	v := validator{root: schema}
+	v.validate(schema, value, root)
+	return v.violations
+}
+
+type validator struct {
+	root       *Schema
+	depth      int
+	violations []budevents.Violation
+}
+
+func (v *validator) violate(field string, message string, args ...interface{}) {
+	v.violations = append(v.violations, budevents.Violation{
+		Field:   field,
+		Message: fmt.Sprintf(message, args...),
+	})
+}
+
+// matches reports whether a value matches a schema without recording why not,
+// for the combinators
+func (v *validator) matches(schema *Schema, value interface{}, field string) bool {
+	nested := validator{root: v.root, depth: v.depth}
+	nested.validate(schema, value, field)
+	return len(nested.violations) == 0
+}
+
+func (v *validator) validate(schema *Schema, value interface{}, field string) {
+	if v.depth >= maxDepth {
+		v.violate(field, "is nested too deeply to validate")
+		return
+	}
+
+	v.depth++
+	defer func() { v.depth-- }()
+
+	if schema.boolean != nil {
+		if !*schema.boolean {
+			v.violate(field, "is not allowed")
+		}
+
+		return
+	}
+
+	if schema.Ref != "" {
+		// refs were checked when the schema was parsed
+		ref, _ := v.root.Resolve(schema.Ref)
+		v.validate(ref, value, field)
+	}
+
+	if len(schema.Type) > 0 && !schema.Type.match(value) {
+		v.violate(field, "must be of type %s", strings.Join(schema.Type, " or "))
+		return
+	}
+
+	if len(schema.Enum) > 0 && !contains(schema.Enum, value) {
+		v.violate(field, "must be one of %s", enumList(schema.Enum))
+	}
+
+	if schema.Const != nil && !equal(*schema.Const, value) {
+		v.violate(field, "must be %s", enumList([]interface{}{*schema.Const}))
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		v.validateObject(schema, value, field)
+	case []interface{}:
+		v.validateArray(schema, value, field)
+	case string:
+		v.validateString(schema, value, field)
+	case json.Number:
+		v.validateNumber(schema, value, field)
+	}
+
+	for _, sub := range schema.AllOf {
+		v.validate(sub, value, field)
+	}
+
+	if len(schema.AnyOf) > 0 {
+		matched := false
+
+		for _, sub := range schema.AnyOf {
+			if v.matches(sub, value, field) {
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			v.violate(field, "must match at least one of the allowed schemas")
+		}
+	}
+
+	if len(schema.OneOf) > 0 {
+		matched := 0
+
+		for _, sub := range schema.OneOf {
+			if v.matches(sub, value, field) {
+				matched++
+			}
+		}
+
+		if matched != 1 {
+			v.violate(field, "must match exactly one of the allowed schemas, matched [%d]", matched)
+		}
+	}
+
+	if schema.Not != nil && v.matches(schema.Not, value, field) {
+		v.violate(field, "must not match the disallowed schema")
+	}
+}
+
+func (v *validator) validateObject(schema *Schema, object map[string]interface{}, field string) {
+	for _, name := range schema.Required {
+		if _, ok := object[name]; !ok {
+			v.violate(join(field, name), "is required")
+		}
+	}
+
+	for _, name := range sortedKeys(object) {
+		if property, ok := schema.Properties[name]; ok {
+			v.validate(property, object[name], join(field, name))
+		} else if schema.AdditionalProperties != nil {
+			v.validate(schema.AdditionalProperties, object[name], join(field, name))
+		}
+	}
+}
+
+func (v *validator) validateArray(schema *Schema, array []interface{}, field string) {
+	if schema.MinItems != nil && len(array) < *schema.MinItems {
+		v.violate(field, "must have at least %d items", *schema.MinItems)
+	}
+
+	if schema.MaxItems != nil && len(array) > *schema.MaxItems {
+		v.violate(field, "must have at most %d items", *schema.MaxItems)
+	}
+
+	if schema.Items != nil {
+		for i, item := range array {
+			v.validate(schema.Items, item, fmt.Sprintf("%s[%d]", field, i))
+		}
+	}
+}
+
+func (v *validator) validateString(schema *Schema, s string, field string) {
+	length := utf8.RuneCountInString(s)
+
+	if schema.MinLength != nil && length < *schema.MinLength {
+		v.violate(field, "must be at least %d characters", *schema.MinLength)
+	}
+
+	if schema.MaxLength != nil && length > *schema.MaxLength {
+		v.violate(field, "must be at most %d characters", *schema.MaxLength)
+	}
+
+	if schema.pattern != nil && !schema.pattern.MatchString(s) {
+		v.violate(field, "must match the pattern [%s]", schema.Pattern)
+	}
+
+	if schema.Format != "" && !validFormat(schema.Format, s) {
+		v.violate(field, "must be a valid %s", schema.Format)
+	}
+}
+
+func (v *validator) validateNumber(schema *Schema, number json.Number, field string) {
+	n, err := number.Float64()
+
+	if err != nil {
+		v.violate(field, "must be a valid number")
+		return
+	}
+
+	if schema.Minimum != nil && n < *schema.Minimum {
+		v.violate(field, "must be at least %v", *schema.Minimum)
+	}
+
+	if schema.Maximum != nil && n > *schema.Maximum {
+		v.violate(field, "must be at most %v", *schema.Maximum)
+	}
+
+	if schema.ExclusiveMinimum != nil && n <= *schema.ExclusiveMinimum {
+		v.violate(field, "must be greater than %v", *schema.ExclusiveMinimum)
+	}
+
+	if schema.ExclusiveMaximum != nil && n >= *schema.ExclusiveMaximum {
+		v.violate(field, "must be less than %v", *schema.ExclusiveMaximum)
+	}
+}
+
+func (t types) match(value interface{}) bool {
+	for _, name := range t {
+		if typeOf(value) == name || (name == "number" && typeOf(value) == "integer") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func typeOf(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	case json.Number:
+		if n, err := value.Float64(); err == nil && n == math.Trunc(n) {
+			return "integer"
+		}
+
+		return "number"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+// validFormat checks the formats event payloads commonly use. Other formats
+// are only annotations, so any value is valid
+func validFormat(format string, s string) bool {
+	switch format {
+	case "date-time":
+		_, err := time.Parse(time.RFC3339, s)
+		return err == nil
+	case "date":
+		_, err := time.Parse("2006-01-02", s)
+		return err == nil
+	case "uuid":
+		_, err := uuid.Parse(s)
+		return err == nil && len(s) == 36
+	case "email":
+		_, err := mail.ParseAddress(s)
+		return err == nil
+	case "uri":
+		u, err := url.Parse(s)
+		return err == nil && u.IsAbs()
+	default:
+		return true
+	}
+}
+
+func contains(values []interface{}, value interface{}) bool {
+	for _, candidate := range values {
+		if equal(candidate, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// equal compares decoded JSON values, treating numbers by their value rather
+// than how they were written
+func equal(a interface{}, b interface{}) bool {
+	return reflect.DeepEqual(normalise(a), normalise(b))
+}
+
+func normalise(value interface{}) interface{} {
+	switch value := value.(type) {
+	case json.Number:
+		n, _ := value.Float64()
+		return n
+	case []interface{}:
+		normalised := make([]interface{}, len(value))
+
+		for i, item := range value {
+			normalised[i] = normalise(item)
+		}
+
+		return normalised
+	case map[string]interface{}:
+		normalised := make(map[string]interface{}, len(value))
+
+		for key, item := range value {
+			normalised[key] = normalise(item)
+		}
+
+		return normalised
+	default:
+		return value
+	}
+}
+
+func enumList(values []interface{}) string {
+	formatted := make([]string, len(values))
+
+	for i, value := range values {
+		blob, _ := json.Marshal(value)
+		formatted[i] = string(blob)
+	}
+
+	return "[" + strings.Join(formatted, ", ") + "]"
+}
+
+// sortedKeys gives violations for an object's properties a stable order
+func sortedKeys(object map[string]interface{}) []string {
+	keys := make([]string, 0, len(object))
+
+	for key := range object {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+func join(field string, name string) string {
+	if field == "" {
+		return name
+	}
+
+	return field + "." + name
+}
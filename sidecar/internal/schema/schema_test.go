@@ -0,0 +1,142 @@
+package schema_test
+
+import (
+	"encoding/json"
+	"github.com/thisisbud/backend-events-sidecar/internal/schema"
+	"strings"
+	"testing"
+)
+
+func TestParseRejectsLoopingRefs(t *testing.T) {
+	tests := map[string]string{
+		"ref to itself":         `{"$defs":{"a":{"$ref":"#/$defs/a"}},"$ref":"#/$defs/a"}`,
+		"ref to the root":       `{"$ref":"#"}`,
+		"refs to each other":    `{"$defs":{"a":{"$ref":"#/$defs/b"},"b":{"$ref":"#/$defs/a"}}}`,
+		"ref through allOf":     `{"allOf":[{"$ref":"#"}]}`,
+		"ref through anyOf":     `{"$defs":{"a":{"anyOf":[{"type":"string"},{"$ref":"#/$defs/a"}]}}}`,
+		"ref through not":       `{"definitions":{"a":{"not":{"$ref":"#/definitions/a"}}}}`,
+		"unused looping define": `{"type":"object","$defs":{"a":{"oneOf":[{"$ref":"#/$defs/a"}]}}}`,
+	}
+
+	for name, blob := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := schema.Parse([]byte(blob)); err == nil {
+				t.Errorf("parsed %s, want an error", blob)
+			}
+		})
+	}
+}
+
+func TestParseAllowsRecursionThroughValues(t *testing.T) {
+	tests := map[string]string{
+		"property":             `{"type":"object","properties":{"child":{"$ref":"#"}}}`,
+		"items":                `{"type":"array","items":{"$ref":"#"}}`,
+		"additionalProperties": `{"$defs":{"tree":{"type":"object","additionalProperties":{"$ref":"#/$defs/tree"}}},"$ref":"#/$defs/tree"}`,
+		"property in allOf":    `{"allOf":[{"properties":{"child":{"$ref":"#"}}}]}`,
+		"shared definition":    `{"$defs":{"a":{"type":"string"}},"allOf":[{"$ref":"#/$defs/a"},{"$ref":"#/$defs/a"}]}`,
+	}
+
+	for name, blob := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := schema.Parse([]byte(blob)); err != nil {
+				t.Errorf("parsing %s: %v", blob, err)
+			}
+		})
+	}
+}
+
+func TestValidateStopsAtDeeplyNestedDocuments(t *testing.T) {
+	s, err := schema.Parse([]byte(`{"type":"array","items":{"$ref":"#"}}`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	document := strings.Repeat("[", 1000) + strings.Repeat("]", 1000)
+	violations := s.Validate(json.RawMessage(document), "payload")
+
+	if len(violations) != 1 || !strings.Contains(violations[0].Message, "nested too deeply") {
+		t.Errorf("got violations %v, want one for nesting too deeply", violations)
+	}
+}
+
+func TestValidateKeywords(t *testing.T) {
+	tests := []struct {
+		name     string
+		schema   string
+		document string
+		valid    bool
+	}{
+		{"type", `{"type":"string"}`, `"a"`, true},
+		{"wrong type", `{"type":"string"}`, `1`, false},
+		{"integer as number", `{"type":"number"}`, `1`, true},
+		{"fraction as integer", `{"type":"integer"}`, `1.5`, false},
+		{"nullable type", `{"type":["string","null"]}`, `null`, true},
+		{"enum", `{"enum":["a","b"]}`, `"b"`, true},
+		{"outside enum", `{"enum":["a","b"]}`, `"c"`, false},
+		{"enum number written differently", `{"enum":[1]}`, `1.0`, true},
+		{"const", `{"const":"a"}`, `"a"`, true},
+		{"different const", `{"const":"a"}`, `"b"`, false},
+		{"minimum", `{"minimum":1}`, `1`, true},
+		{"below minimum", `{"minimum":1}`, `0.5`, false},
+		{"above maximum", `{"maximum":1}`, `2`, false},
+		{"at exclusive minimum", `{"exclusiveMinimum":1}`, `1`, false},
+		{"at exclusive maximum", `{"exclusiveMaximum":1}`, `1`, false},
+		{"min length in characters", `{"minLength":2}`, `"é€"`, true},
+		{"too short", `{"minLength":2}`, `"a"`, false},
+		{"too long", `{"maxLength":1}`, `"ab"`, false},
+		{"pattern", `{"pattern":"^[a-z]+$"}`, `"abc"`, true},
+		{"not matching pattern", `{"pattern":"^[a-z]+$"}`, `"ABC"`, false},
+		{"date-time", `{"format":"date-time"}`, `"2023-01-02T03:04:05Z"`, true},
+		{"bad date-time", `{"format":"date-time"}`, `"yesterday"`, false},
+		{"date", `{"format":"date"}`, `"2023-01-02"`, true},
+		{"bad date", `{"format":"date"}`, `"2023-13-02"`, false},
+		{"uuid", `{"format":"uuid"}`, `"123e4567-e89b-12d3-a456-426614174000"`, true},
+		{"bad uuid", `{"format":"uuid"}`, `"123e4567"`, false},
+		{"email", `{"format":"email"}`, `"a@example.com"`, true},
+		{"bad email", `{"format":"email"}`, `"example.com"`, false},
+		{"uri", `{"format":"uri"}`, `"https://example.com"`, true},
+		{"relative uri", `{"format":"uri"}`, `"/path"`, false},
+		{"unknown format", `{"format":"colour"}`, `"anything"`, true},
+		{"required", `{"required":["a"]}`, `{"a":1}`, true},
+		{"missing required", `{"required":["a"]}`, `{}`, false},
+		{"property", `{"properties":{"a":{"type":"string"}}}`, `{"a":"x"}`, true},
+		{"bad property", `{"properties":{"a":{"type":"string"}}}`, `{"a":1}`, false},
+		{"additional properties allowed", `{"properties":{"a":{}}}`, `{"b":1}`, true},
+		{"additional properties false", `{"properties":{"a":{}},"additionalProperties":false}`, `{"b":1}`, false},
+		{"additional properties schema", `{"additionalProperties":{"type":"integer"}}`, `{"b":"x"}`, false},
+		{"items", `{"items":{"type":"integer"}}`, `[1,2]`, true},
+		{"bad item", `{"items":{"type":"integer"}}`, `[1,"2"]`, false},
+		{"too few items", `{"minItems":1}`, `[]`, false},
+		{"too many items", `{"maxItems":1}`, `[1,2]`, false},
+		{"allOf", `{"allOf":[{"type":"integer"},{"minimum":1}]}`, `2`, true},
+		{"failing allOf", `{"allOf":[{"type":"integer"},{"minimum":1}]}`, `0`, false},
+		{"anyOf", `{"anyOf":[{"type":"string"},{"type":"integer"}]}`, `1`, true},
+		{"failing anyOf", `{"anyOf":[{"type":"string"},{"type":"integer"}]}`, `true`, false},
+		{"oneOf", `{"oneOf":[{"type":"string"},{"type":"integer"}]}`, `1`, true},
+		{"oneOf matching both", `{"oneOf":[{"type":"number"},{"type":"integer"}]}`, `1`, false},
+		{"not", `{"not":{"type":"string"}}`, `1`, true},
+		{"failing not", `{"not":{"type":"string"}}`, `"a"`, false},
+		{"ref", `{"$defs":{"id":{"type":"string"}},"properties":{"a":{"$ref":"#/$defs/id"}}}`, `{"a":"x"}`, true},
+		{"failing ref", `{"$defs":{"id":{"type":"string"}},"properties":{"a":{"$ref":"#/$defs/id"}}}`, `{"a":1}`, false},
+		{"recursive ref", `{"properties":{"child":{"$ref":"#"}},"required":["n"]}`, `{"n":1,"child":{"child":{}}}`, false},
+		{"true schema", `true`, `"anything"`, true},
+		{"false schema", `{"properties":{"a":false}}`, `{"a":1}`, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := schema.Parse([]byte(test.schema))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			violations := s.Validate(json.RawMessage(test.document), "payload")
+
+			if valid := len(violations) == 0; valid != test.valid {
+				t.Errorf("validating %s against %s gave %v, want valid [%t]", test.document, test.schema, violations, test.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+// Package sidecartest runs an in-process sidecar for tests
+package sidecartest
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/thisisbud/backend-events-sidecar/internal/handlers"
+	"github.com/thisisbud/backend-events-sidecar/internal/schema"
+	"github.com/thisisbud/backend-events-sidecar/internal/storage/memory"
+	"github.com/thisisbud/backend-events-sidecar/pkg/budevents"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// New runs a sidecar backed by memory storage for the length of a test
+func New(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	repo := memory.NewEventRepository()
+	router := handlers.NewRouter(handlers.Storage{
+		PublishEvent:          repo.Publish,
+		PublishEvents:         repo.PublishBatch,
+		GetEvent:              repo.GetEvent,
+		GetLatestEvent:        repo.GetLatestEvent,
+		GetEventAt:            repo.GetEventAt,
+		GetSubjectEvent:       repo.GetSubjectEvent,
+		GetLatestSubjectEvent: repo.GetLatestSubjectEvent,
+	}, schema.NewRegistry(), schema.CompatibilityBackward, uuid.NewString)
+
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// Stream reads the events in a sidecar's stream, oldest first
+func Stream(t *testing.T, baseURL string) []budevents.Event {
+	t.Helper()
+
+	var events []budevents.Event
+	href := "/v1/events"
+
+	for href != "" {
+		resp, err := http.Get(baseURL + href)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.StatusCode == http.StatusNotFound {
+			resp.Body.Close()
+			break
+		}
+
+		var body budevents.Response
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		events = append([]budevents.Event{body.Data}, events...)
+		href = body.Metadata["next"].Href
+	}
+
+	return events
+}
+
+// StreamNames reads the names of the events in a sidecar's stream, oldest
+// first
+func StreamNames(t *testing.T, baseURL string) []string {
+	t.Helper()
+
+	var names []string
+
+	for _, event := range Stream(t, baseURL) {
+		names = append(names, event.EventName)
+	}
+
+	return names
+}
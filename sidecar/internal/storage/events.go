@@ -4,12 +4,66 @@ import (
 	"context"
 	"errors"
 	"github.com/thisisbud/backend-events-sidecar/pkg/budevents"
+	"time"
 )
 
-type PublishEvent func(ctx context.Context, event budevents.Event) error
+// PublishEvent stores an event at the head of the stream, returning it with
+// the next sequence number of the stream. When expectedPreviousEventID is set,
+// the event is only stored if that is still the latest event, checked
+// atomically with storing it
+type PublishEvent func(
+	ctx context.Context,
+	event budevents.Event,
+	expectedPreviousEventID string,
+) (*budevents.Event, error)
 
-type GetEvent func(ctx context.Context, eventID string) (*budevents.Event, map[string]budevents.Reference, error)
+// PublishEvents stores several events at the head of the stream in order,
+// either storing all of them or none. Their sequence numbers are contiguous
+type PublishEvents func(
+	ctx context.Context,
+	events []budevents.Event,
+	expectedPreviousEventID string,
+) ([]budevents.Event, error)
 
-type GetLatestEvent func(ctx context.Context) (*budevents.Event, map[string]budevents.Reference, error)
+// GetEvent and GetLatestEvent read the stream. Given event names, they read a
+// view of it with only those events, so the event must have one of the names
+// and its links skip events without them
+type GetEvent func(
+	ctx context.Context,
+	eventID string,
+	eventNames []string,
+) (*budevents.Event, map[string]budevents.Reference, error)
+
+type GetLatestEvent func(
+	ctx context.Context,
+	eventNames []string,
+) (*budevents.Event, map[string]budevents.Reference, error)
+
+// GetEventAt reads the latest event published at or before a point in time,
+// linked to walk back from there as GetEvent would
+type GetEventAt func(
+	ctx context.Context,
+	at time.Time,
+	eventNames []string,
+) (*budevents.Event, map[string]budevents.Reference, error)
+
+// GetSubjectEvent and GetLatestSubjectEvent read the stream of events about a
+// single subject, linked in the same way as the whole stream
+type GetSubjectEvent func(
+	ctx context.Context,
+	subject string,
+	eventID string,
+) (*budevents.Event, map[string]budevents.Reference, error)
+
+type GetLatestSubjectEvent func(
+	ctx context.Context,
+	subject string,
+) (*budevents.Event, map[string]budevents.Reference, error)
 
 var ErrEventNotFound = errors.New("event not found")
+
+// ErrDuplicateEvent is returned when publishing an event whose ID has already
+// been published. Storage must check this atomically with storing the event
+var ErrDuplicateEvent = errors.New("event already exists")
+
+var ErrPreconditionFailed = errors.New("latest event is not the expected previous event")
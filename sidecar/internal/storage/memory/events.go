@@ -5,86 +5,325 @@ import (
 	"github.com/thisisbud/backend-events-sidecar/internal/storage"
 	"github.com/thisisbud/backend-events-sidecar/pkg/budevents"
 	"net/http"
+	"net/url"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
+// eventRepository keeps events in the order they were published, oldest
+// first, alongside the position of each event ID and the positions of the
+// events about each subject and with each name. The times events were
+// published are kept in the same order, never going backwards, so that they
+// can be searched
 type eventRepository struct {
-	mu     *sync.Mutex
-	events []budevents.Event
+	mu          *sync.Mutex
+	events      []budevents.Event
+	publishedAt []time.Time
+	positions   map[string]int
+	subjects    map[string][]int
+	names       map[string][]int
+	now         func() time.Time
 }
 
 func NewEventRepository() *eventRepository {
 	return &eventRepository{
-		mu:     new(sync.Mutex),
-		events: []budevents.Event{},
+		mu:        new(sync.Mutex),
+		events:    []budevents.Event{},
+		positions: map[string]int{},
+		subjects:  map[string][]int{},
+		names:     map[string][]int{},
+		now:       time.Now,
 	}
 }
 
-func (repo *eventRepository) Publish(ctx context.Context, event budevents.Event) error {
+func (repo *eventRepository) Publish(
+	ctx context.Context,
+	event budevents.Event,
+	expectedPreviousEventID string,
+) (*budevents.Event, error) {
+	published, err := repo.PublishBatch(ctx, []budevents.Event{event}, expectedPreviousEventID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &published[0], nil
+}
+
+func (repo *eventRepository) PublishBatch(
+	ctx context.Context,
+	events []budevents.Event,
+	expectedPreviousEventID string,
+) ([]budevents.Event, error) {
 	repo.mu.Lock()
-	repo.events = append([]budevents.Event{event}, repo.events...)
-	repo.mu.Unlock()
+	defer repo.mu.Unlock()
+
+	batch := map[string]bool{}
+
+	for _, event := range events {
+		if _, ok := repo.positions[event.EventID]; ok || batch[event.EventID] {
+			return nil, storage.ErrDuplicateEvent
+		}
+
+		batch[event.EventID] = true
+	}
+
+	if expectedPreviousEventID != "" && repo.latestEventID() != expectedPreviousEventID {
+		return nil, storage.ErrPreconditionFailed
+	}
+
+	published := make([]budevents.Event, len(events))
+	publishedAt := repo.now()
 
-	return nil
+	if n := len(repo.publishedAt); n > 0 && publishedAt.Before(repo.publishedAt[n-1]) {
+		publishedAt = repo.publishedAt[n-1]
+	}
+
+	for i, event := range events {
+		// sequences count up from 1, so are one ahead of the position
+		event.Sequence = int64(len(repo.events) + 1)
+		repo.positions[event.EventID] = len(repo.events)
+
+		if event.Subject != "" {
+			repo.subjects[event.Subject] = append(repo.subjects[event.Subject], len(repo.events))
+		}
+
+		repo.names[event.EventName] = append(repo.names[event.EventName], len(repo.events))
+		repo.events = append(repo.events, event)
+		repo.publishedAt = append(repo.publishedAt, publishedAt)
+		published[i] = event
+	}
+
+	return published, nil
 }
 
 func (repo *eventRepository) GetLatestEvent(
 	ctx context.Context,
+	eventNames []string,
 ) (*budevents.Event, map[string]budevents.Reference, error) {
 	repo.mu.Lock()
 	defer repo.mu.Unlock()
 
+	if len(eventNames) > 0 {
+		position := repo.previousNamed(eventNames, len(repo.events))
+
+		if position < 0 {
+			return nil, nil, storage.ErrEventNotFound
+		}
+
+		return repo.namedEventAt(position, eventNames)
+	}
+
 	if len(repo.events) == 0 {
 		return nil, nil, storage.ErrEventNotFound
 	}
 
-	if len(repo.events) == 1 {
-		return &repo.events[0], map[string]budevents.Reference{
-			"self": {
-				Href: "/v1/events/" + repo.events[0].EventID,
-				Type: http.MethodGet,
-			},
-		}, nil
+	return repo.eventAt(len(repo.events) - 1)
+}
+
+func (repo *eventRepository) GetEventAt(
+	ctx context.Context,
+	at time.Time,
+	eventNames []string,
+) (*budevents.Event, map[string]budevents.Reference, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	// the number of events published at or before the time
+	count := sort.Search(len(repo.publishedAt), func(i int) bool {
+		return repo.publishedAt[i].After(at)
+	})
+
+	if len(eventNames) > 0 {
+		position := repo.previousNamed(eventNames, count)
+
+		if position < 0 {
+			return nil, nil, storage.ErrEventNotFound
+		}
+
+		return repo.namedEventAt(position, eventNames)
+	}
+
+	if count == 0 {
+		return nil, nil, storage.ErrEventNotFound
 	}
 
-	return &repo.events[0], map[string]budevents.Reference{
-		"self": {
-			Href: "/v1/events/" + repo.events[0].EventID,
-			Type: http.MethodGet,
-		},
-		"next": {
-			Href: "/v1/events/" + repo.events[1].EventID,
-			Type: http.MethodGet,
-		},
-	}, nil
+	return repo.eventAt(count - 1)
 }
 
 func (repo *eventRepository) GetEvent(
 	ctx context.Context,
 	eventID string,
+	eventNames []string,
 ) (*budevents.Event, map[string]budevents.Reference, error) {
 	repo.mu.Lock()
 	defer repo.mu.Unlock()
 
-	refs := map[string]budevents.Reference{}
+	position, ok := repo.positions[eventID]
 
-	for i, e := range repo.events {
-		if e.EventID == eventID {
-			refs["self"] = budevents.Reference{
-				Href: "/v1/events/" + e.EventID,
-				Type: http.MethodGet,
-			}
+	if !ok {
+		return nil, nil, storage.ErrEventNotFound
+	}
+
+	if len(eventNames) > 0 {
+		if !contains(eventNames, repo.events[position].EventName) {
+			return nil, nil, storage.ErrEventNotFound
+		}
+
+		return repo.namedEventAt(position, eventNames)
+	}
+
+	return repo.eventAt(position)
+}
 
-			if i < len(repo.events)-1 {
-				refs["next"] = budevents.Reference{
-					Href: "/v1/events/" + repo.events[i+1].EventID,
-					Type: http.MethodGet,
-				}
-			}
+func (repo *eventRepository) GetLatestSubjectEvent(
+	ctx context.Context,
+	subject string,
+) (*budevents.Event, map[string]budevents.Reference, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	positions := repo.subjects[subject]
+
+	if len(positions) == 0 {
+		return nil, nil, storage.ErrEventNotFound
+	}
+
+	return repo.subjectEventAt(subject, len(positions)-1)
+}
+
+func (repo *eventRepository) GetSubjectEvent(
+	ctx context.Context,
+	subject string,
+	eventID string,
+) (*budevents.Event, map[string]budevents.Reference, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	position, ok := repo.positions[eventID]
+
+	if !ok || repo.events[position].Subject != subject {
+		return nil, nil, storage.ErrEventNotFound
+	}
+
+	// positions are appended in order, so the subject's index is sorted
+	return repo.subjectEventAt(subject, sort.SearchInts(repo.subjects[subject], position))
+}
+
+func (repo *eventRepository) latestEventID() string {
+	if len(repo.events) == 0 {
+		return ""
+	}
+
+	return repo.events[len(repo.events)-1].EventID
+}
+
+func (repo *eventRepository) eventAt(position int) (*budevents.Event, map[string]budevents.Reference, error) {
+	event := repo.events[position]
+	refs := map[string]budevents.Reference{
+		"self": {
+			Href:     "/v1/events/" + event.EventID,
+			Type:     http.MethodGet,
+			Sequence: event.Sequence,
+		},
+	}
+
+	if position > 0 {
+		refs["next"] = budevents.Reference{
+			Href:     "/v1/events/" + repo.events[position-1].EventID,
+			Type:     http.MethodGet,
+			Sequence: repo.events[position-1].Sequence,
+		}
+	}
+
+	return &event, refs, nil
+}
+
+// namedEventAt links an event to the previous event with one of the names,
+// keeping the names in the links. Sequences are left off the links, since the
+// view skips events by design
+func (repo *eventRepository) namedEventAt(
+	position int,
+	eventNames []string,
+) (*budevents.Event, map[string]budevents.Reference, error) {
+	escaped := make([]string, len(eventNames))
+
+	for i, eventName := range eventNames {
+		escaped[i] = url.QueryEscape(eventName)
+	}
+
+	query := "?event_name=" + strings.Join(escaped, ",")
+	event := repo.events[position]
+	refs := map[string]budevents.Reference{
+		"self": {
+			Href: "/v1/events/" + event.EventID + query,
+			Type: http.MethodGet,
+		},
+	}
+
+	if previous := repo.previousNamed(eventNames, position); previous >= 0 {
+		refs["next"] = budevents.Reference{
+			Href: "/v1/events/" + repo.events[previous].EventID + query,
+			Type: http.MethodGet,
+		}
+	}
+
+	return &event, refs, nil
+}
+
+// previousNamed finds the position of the latest event before position with
+// one of the names, or -1 if there is none, searching the index of each name
+func (repo *eventRepository) previousNamed(eventNames []string, position int) int {
+	previous := -1
+
+	for _, eventName := range eventNames {
+		positions := repo.names[eventName]
+		i := sort.SearchInts(positions, position)
+
+		if i > 0 && positions[i-1] > previous {
+			previous = positions[i-1]
+		}
+	}
+
+	return previous
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// subjectEventAt links an event to the previous event about the same subject,
+// numbering events by their position in the subject's stream
+func (repo *eventRepository) subjectEventAt(
+	subject string,
+	index int,
+) (*budevents.Event, map[string]budevents.Reference, error) {
+	positions := repo.subjects[subject]
+	event := repo.events[positions[index]]
+	prefix := "/v1/subjects/" + url.PathEscape(subject) + "/events/"
+	refs := map[string]budevents.Reference{
+		"self": {
+			Href:     prefix + event.EventID,
+			Type:     http.MethodGet,
+			Sequence: int64(index + 1),
+		},
+	}
 
-			return &e, refs, nil
+	if index > 0 {
+		refs["next"] = budevents.Reference{
+			Href:     prefix + repo.events[positions[index-1]].EventID,
+			Type:     http.MethodGet,
+			Sequence: int64(index),
 		}
 	}
 
-	return nil, nil, storage.ErrEventNotFound
+	return &event, refs, nil
 }
@@ -2,11 +2,11 @@ package main
 
 import (
 	"flag"
-	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/cors"
 	"github.com/google/uuid"
 	_ "github.com/joho/godotenv/autoload"
 	"github.com/thisisbud/backend-events-sidecar/internal/handlers"
+	"github.com/thisisbud/backend-events-sidecar/internal/schema"
 	"github.com/thisisbud/backend-events-sidecar/internal/storage/memory"
 	"log"
 	"net/http"
@@ -16,16 +16,41 @@ import (
 func main() {
 	repo := memory.NewEventRepository()
 	port := flag.String("port", os.Getenv("HTTP_PORT"), "HTTP port to run on")
+	schemaDir := flag.String("schema-dir", os.Getenv("SCHEMA_DIR"), "directory of payload schemas, as <event_name>/<version>.json")
+	compatibility := flag.String("schema-compatibility", os.Getenv("SCHEMA_COMPATIBILITY"), "compatibility new schema versions must have: none, backward, forward or full")
 
 	flag.Parse()
 
-	r := chi.NewRouter()
-	r.Use(cors.AllowAll().Handler)
-	r.Get("/", handlers.Wellknown)
-	r.Get("/v1/events", handlers.GetLatestEvent(repo.GetLatestEvent))
-	r.Get("/v1/events/{event_id}", handlers.GetEvent(repo.GetEvent))
-	r.Post("/v1/events", handlers.PublishEvent(repo.Publish, uuid.NewString))
+	if *compatibility == "" {
+		*compatibility = string(schema.CompatibilityBackward)
+	}
+
+	requiredCompatibility, err := schema.ParseCompatibility(*compatibility)
+
+	if err != nil {
+		log.Panic(err)
+	}
+
+	schemas := schema.NewRegistry()
+
+	if *schemaDir != "" {
+		schemas, err = schema.LoadDir(*schemaDir)
+
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+
+	router := handlers.NewRouter(handlers.Storage{
+		PublishEvent:          repo.Publish,
+		PublishEvents:         repo.PublishBatch,
+		GetEvent:              repo.GetEvent,
+		GetLatestEvent:        repo.GetLatestEvent,
+		GetEventAt:            repo.GetEventAt,
+		GetSubjectEvent:       repo.GetSubjectEvent,
+		GetLatestSubjectEvent: repo.GetLatestSubjectEvent,
+	}, schemas, requiredCompatibility, uuid.NewString)
 
 	log.Printf("running on port %s\n", *port)
-	log.Panic(http.ListenAndServe(":"+*port, r))
+	log.Panic(http.ListenAndServe(":"+*port, cors.AllowAll().Handler(router)))
 }
@@ -0,0 +1,168 @@
+package budevents
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+)
+
+// envReference matches a reference to an environment variable, like
+// ${EVENTS_BASE_URL}
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// LoadListeners reads and validates a JSON config file holding a list of
+// listeners. References to environment variables inside its strings, such as
+// ${EVENTS_BASE_URL}, are replaced with their values, and must be set
+func LoadListeners(filename string) ([]Listener, error) {
+	blob, err := os.ReadFile(filename)
+
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(blob))
+	decoder.UseNumber()
+
+	var config interface{}
+
+	if err := decoder.Decode(&config); err != nil {
+		return nil, err
+	}
+
+	if decoder.More() {
+		return nil, fmt.Errorf("unexpected data after the listeners in [%s]", filename)
+	}
+
+	config, err = expandEnv(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// the expanded values are re-encoded, so they cannot change the structure
+	blob, err = json.Marshal(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var listeners []Listener
+
+	if err := json.Unmarshal(blob, &listeners); err != nil {
+		return nil, err
+	}
+
+	if err := validateListeners(listeners); err != nil {
+		return nil, err
+	}
+
+	return listeners, nil
+}
+
+// WatchConfig polls the modification time of a config file loaded with
+// LoadListeners, and updates the listeners of the consumer whenever the file
+// changes. A config that fails to load is passed to onError and otherwise
+// ignored, leaving the consumer running with the last valid one
+func WatchConfig(
+	ctx context.Context,
+	consumer Consumer,
+	filename string,
+	interval time.Duration,
+	onError func(err error),
+) error {
+	var lastModified time.Time
+
+	if info, err := os.Stat(filename); err == nil {
+		lastModified = info.ModTime()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(filename)
+
+		if err != nil {
+			onError(err)
+			continue
+		}
+
+		if info.ModTime().Equal(lastModified) {
+			continue
+		}
+
+		lastModified = info.ModTime()
+		listeners, err := LoadListeners(filename)
+
+		if err != nil {
+			onError(err)
+			continue
+		}
+
+		if err := consumer.SetListeners(listeners...); err != nil {
+			onError(err)
+		}
+	}
+}
+
+// expandEnv replaces references to environment variables in the strings of a
+// decoded JSON value
+func expandEnv(value interface{}) (interface{}, error) {
+	switch value := value.(type) {
+	case string:
+		var missing []string
+
+		expanded := envReference.ReplaceAllStringFunc(value, func(reference string) string {
+			name := envReference.FindStringSubmatch(reference)[1]
+			env, ok := os.LookupEnv(name)
+
+			if !ok {
+				missing = append(missing, name)
+			}
+
+			return env
+		})
+
+		if len(missing) > 0 {
+			return nil, fmt.Errorf("environment variable [%s] is not set", missing[0])
+		}
+
+		return expanded, nil
+	case []interface{}:
+		for i, item := range value {
+			expanded, err := expandEnv(item)
+
+			if err != nil {
+				return nil, err
+			}
+
+			value[i] = expanded
+		}
+
+		return value, nil
+	case map[string]interface{}:
+		for key, item := range value {
+			expanded, err := expandEnv(item)
+
+			if err != nil {
+				return nil, err
+			}
+
+			value[key] = expanded
+		}
+
+		return value, nil
+	default:
+		return value, nil
+	}
+}
@@ -0,0 +1,66 @@
+package budevents_test
+
+import (
+	"github.com/thisisbud/backend-events-sidecar/pkg/budevents"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func loadListeners(t *testing.T, config string) ([]budevents.Listener, error) {
+	t.Helper()
+
+	filename := filepath.Join(t.TempDir(), "listeners.json")
+
+	if err := os.WriteFile(filename, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return budevents.LoadListeners(filename)
+}
+
+func TestLoadListenersExpandsEnvironmentVariables(t *testing.T) {
+	t.Setenv("EVENTS_BASE_URL", "http://localhost:8080")
+	t.Setenv("EVENTS_PATH", `events?q="quoted" \\ "`+"\n"+`", "last_event_id": "injected`)
+	t.Setenv("EVENT_NAME", "order_placed")
+
+	listeners, err := loadListeners(t, `[{
+		"base_url": "${EVENTS_BASE_URL}",
+		"well_known_path": "/v1/${EVENTS_PATH}&note=$EVENTS_BASE_URL",
+		"event_names": ["${EVENT_NAME}"]
+	}]`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if listeners[0].BaseURL != "http://localhost:8080" {
+		t.Errorf("base_url is [%s], want it expanded", listeners[0].BaseURL)
+	}
+
+	if want := "/v1/" + os.Getenv("EVENTS_PATH") + "&note=$EVENTS_BASE_URL"; listeners[0].WellKnownPath != want {
+		t.Errorf("well_known_path is [%s], want [%s] with the bare $ left alone", listeners[0].WellKnownPath, want)
+	}
+
+	if want := []string{"order_placed"}; !reflect.DeepEqual(listeners[0].EventNames, want) {
+		t.Errorf("event_names are %q, want %q", listeners[0].EventNames, want)
+	}
+
+	if listeners[0].LastEventID != "" {
+		t.Errorf("last_event_id is [%s], want the variable kept inside its string", listeners[0].LastEventID)
+	}
+}
+
+func TestLoadListenersRejectsUnsetEnvironmentVariables(t *testing.T) {
+	// set first so that it is restored after the test
+	t.Setenv("EVENTS_UNSET_BASE_URL", "")
+	os.Unsetenv("EVENTS_UNSET_BASE_URL")
+
+	_, err := loadListeners(t, `[{"base_url": "${EVENTS_UNSET_BASE_URL}"}]`)
+
+	if err == nil || !strings.Contains(err.Error(), "EVENTS_UNSET_BASE_URL") {
+		t.Errorf("loaded with [%v], want an error naming the unset variable", err)
+	}
+}
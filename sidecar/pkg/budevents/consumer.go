@@ -7,84 +7,361 @@ import (
 	"fmt"
 	"golang.org/x/sync/errgroup"
 	"net/http"
+	"net/url"
 	"sort"
+	"strings"
 	"time"
 )
 
 type Consumer struct {
-	listeners []Listener
-	callback  func(ctx context.Context, events ...Event) error
+	listeners    *listenerSet
+	callback     Callback
+	middleware   []Middleware
+	hooks        []Hooks
+	partitioning *Partitioning
+	inbox        Inbox
+	upcasters    map[upcasterKey]Upcaster
+	metrics      *metrics
 }
 
 func NewConsumer(
-	callback func(ctx context.Context, events ...Event) error,
+	callback Callback,
 	listeners ...Listener,
-) Consumer {
+) (Consumer, error) {
+	if err := validateListeners(listeners); err != nil {
+		return Consumer{}, err
+	}
+
+	metrics := newMetrics()
+	set := newListenerSet(listeners)
+
 	return Consumer{
-		listeners: listeners,
+		listeners: set,
 		callback:  callback,
+		hooks:     []Hooks{metrics, set},
+		metrics:   metrics,
+	}, nil
+}
+
+// WithPartitioning processes the events of each poll concurrently on a pool
+// of workers, preserving the order of events that share the same key
+func (consumer Consumer) WithPartitioning(key func(event Event) string, workers int) Consumer {
+	consumer.partitioning = &Partitioning{
+		Key:     key,
+		Workers: workers,
 	}
+
+	return consumer
 }
 
 type Listener struct {
-	BaseURL       string   `json:"base_url"`
+	BaseURL string `json:"base_url"`
+	// WellKnownPath is discovered from the root of BaseURL when left empty,
+	// and the Ticker defaults to 5s
 	WellKnownPath string   `json:"well_known_path"`
 	Ticker        Duration `json:"ticker"`
+	MinTicker     Duration `json:"min_ticker,omitempty"`
+	MaxTicker     Duration `json:"max_ticker,omitempty"`
+	Jitter        float64  `json:"jitter,omitempty"`
 	LastEventID   string   `json:"last_event_id"`
+
+	// EventNames asks the sidecar for only the events with these names, so
+	// that the others are skipped without being fetched. The names are part of
+	// the stream, so changing them starts a new one with its own checkpoint
+	EventNames []string `json:"event_names,omitempty"`
+
+	// StartPosition decides where a listener without a checkpoint of its own
+	// starts consuming from. StartTime is the boundary for StartFromTimestamp
+	// and StartFromPointInTime
+	StartPosition StartPosition `json:"start_position,omitempty"`
+	StartTime     time.Time     `json:"start_time,omitempty"`
+}
+
+type StartPosition string
+
+const (
+	StartFromBeginning StartPosition = "beginning"
+	StartFromLatest    StartPosition = "latest"
+	StartFromEventID   StartPosition = "event_id"
+	StartFromTimestamp StartPosition = "timestamp"
+	// StartFromPointInTime asks the sidecar for the latest event published at
+	// or before StartTime, with ?at=, and consumes the events after it. Only
+	// the /v1/events stream serves ?at=, so the WellKnownPath must be it or
+	// left to be discovered
+	StartFromPointInTime StartPosition = "point_in_time"
+)
+
+func (conf Listener) key() string {
+	return withEventNames(conf.BaseURL+conf.WellKnownPath, conf.EventNames)
+}
+
+// withEventNames adds an event_name filter to a path, keeping its query
+func withEventNames(path string, eventNames []string) string {
+	if len(eventNames) == 0 {
+		return path
+	}
+
+	names := append([]string(nil), eventNames...)
+	sort.Strings(names)
+
+	for i, name := range names {
+		names[i] = url.QueryEscape(name)
+	}
+
+	return withQuery(path, "event_name="+strings.Join(names, ","))
+}
+
+func withQuery(path string, query string) string {
+	if strings.Contains(path, "?") {
+		return path + "&" + query
+	}
+
+	return path + "?" + query
+}
+
+func (conf Listener) startPosition() StartPosition {
+	if conf.StartPosition != "" {
+		return conf.StartPosition
+	}
+
+	if conf.LastEventID != "" {
+		return StartFromEventID
+	}
+
+	return StartFromBeginning
 }
 
 func (consumer Consumer) Consume(ctx context.Context) error {
 	errs, ctx := errgroup.WithContext(ctx)
 
-	for _, listener := range consumer.listeners {
-		errs.Go(func(l Listener) func() error {
-			return func() error {
-				return consumer.consumeEvents(ctx, l)
+	consumer.listeners.run(func(conf Listener) func() {
+		listenerCtx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+
+		errs.Go(func() error {
+			defer close(done)
+			err := consumer.consumeEvents(listenerCtx, conf)
+
+			// the listener was stopped because it was removed or reconfigured
+			if listenerCtx.Err() != nil && ctx.Err() == nil {
+				return nil
 			}
-		}(listener))
-	}
+
+			return err
+		})
+
+		return func() {
+			cancel()
+			<-done
+		}
+	})
+
+	errs.Go(func() error {
+		<-ctx.Done()
+		consumer.listeners.stop()
+		return ctx.Err()
+	})
 
 	return errs.Wait()
 }
 
 func (consumer Consumer) consumeEvents(ctx context.Context, conf Listener) error {
-	lastEventID := conf.LastEventID
+	wellKnownPath, lastEventID, err := consumer.start(ctx, conf)
 
-	for range time.Tick(time.Duration(conf.Ticker)) {
-		events, err := findLatestEvents(conf.BaseURL, conf.WellKnownPath, lastEventID)
+	if err != nil {
+		consumer.notify(func(hooks Hooks) { hooks.OnError(ctx, conf, err) })
+		return err
+	}
+
+	position := conf.startPosition()
+	poller := newPoller(conf)
+	timer := time.NewTimer(poller.jittered(poller.interval))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		var since time.Time
+
+		if position == StartFromTimestamp && lastEventID == "" {
+			since = conf.StartTime
+		}
+
+		consumer.notify(func(hooks Hooks) { hooks.OnPollStart(ctx, conf) })
+		polledAt := time.Now()
+		events, gaps, err := findLatestEvents(conf.BaseURL, wellKnownPath, lastEventID, since)
 
 		if err != nil {
+			consumer.notify(func(hooks Hooks) { hooks.OnError(ctx, conf, err) })
 			return err
 		}
 
-		if err := consumer.callback(ctx, events...); err != nil {
-			return err
+		latency := time.Since(polledAt)
+		consumer.notify(func(hooks Hooks) { hooks.OnPollComplete(ctx, conf, events, latency) })
+
+		for _, gap := range gaps {
+			consumer.notify(func(hooks Hooks) { hooks.OnError(ctx, conf, gap) })
 		}
 
-		if len(events) > 0 {
-			lastEventID = events[len(events)-1].EventID
+		deliveredAt := time.Now()
+		processed, err := consumer.process(ctx, events)
+		latency = time.Since(deliveredAt)
+		consumer.notify(func(hooks Hooks) { hooks.OnEventsDelivered(ctx, conf, events, processed, latency) })
+
+		if processed > 0 {
+			lastEventID = events[processed-1].EventID
+			consumer.notify(func(hooks Hooks) { hooks.OnCheckpoint(ctx, conf, lastEventID) })
 		}
+
+		if err != nil {
+			consumer.notify(func(hooks Hooks) { hooks.OnError(ctx, conf, err) })
+			return err
+		}
+
+		timer.Reset(poller.next(len(events) > 0))
 	}
+}
 
-	return nil
+// start finds the path a listener polls and the event it starts after,
+// checkpointing it when it was looked up from the sidecar
+func (consumer Consumer) start(ctx context.Context, conf Listener) (string, string, error) {
+	wellKnownPath, err := discoverWellKnownPath(conf)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	var lastEventID string
+	lookedUp := false
+
+	switch position := conf.startPosition(); position {
+	case StartFromBeginning:
+	case StartFromEventID, StartFromTimestamp:
+		lastEventID = conf.LastEventID
+	case StartFromLatest:
+		lastEventID, err = findHeadEventID(conf.BaseURL, wellKnownPath)
+		lookedUp = true
+	case StartFromPointInTime:
+		at := withQuery(wellKnownPath, "at="+url.QueryEscape(conf.StartTime.Format(time.RFC3339Nano)))
+		lastEventID, err = findHeadEventID(conf.BaseURL, at)
+		lookedUp = true
+	default:
+		err = fmt.Errorf("unknown start position [%s]", position)
+	}
+
+	if err != nil {
+		return "", "", err
+	}
+
+	if lookedUp && lastEventID != "" {
+		consumer.notify(func(hooks Hooks) { hooks.OnCheckpoint(ctx, conf, lastEventID) })
+	}
+
+	return wellKnownPath, lastEventID, nil
+}
+
+// process hands the events to the callback, returning how many of them (from
+// the oldest) have been fully processed and can be checkpointed
+func (consumer Consumer) process(ctx context.Context, events []Event) (int, error) {
+	callback := consumer.handler()
+
+	if consumer.partitioning != nil && len(events) > 0 {
+		return consumer.partitioning.process(ctx, callback, events)
+	}
+
+	// a batch has no single cause, see HandleEach
+	if len(events) == 1 {
+		ctx = ContextWithCause(ctx, events[0])
+	}
+
+	if err := callback(ctx, events...); err != nil {
+		return 0, err
+	}
+
+	return len(events), nil
+}
+
+// discoverWellKnownPath follows the latest link served from the root of the
+// base URL when the listener has no well-known path configured, filtered to
+// the listener's event names
+func discoverWellKnownPath(conf Listener) (string, error) {
+	if conf.WellKnownPath != "" {
+		return withEventNames(conf.WellKnownPath, conf.EventNames), nil
+	}
+
+	resp, err := http.Get(strings.TrimSuffix(conf.BaseURL, "/") + "/")
+
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("bad status code [%d] discovering well-known path", resp.StatusCode)
+	}
+
+	var body Discovery
+
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+
+	if body.Metadata["latest"].Href == "" {
+		return "", fmt.Errorf("no latest link discovered at [%s]", conf.BaseURL)
+	}
+
+	return withEventNames(body.Metadata["latest"].Href, conf.EventNames), nil
 }
 
-func findLatestEvents(baseURL string, wellknownURL string, latestEventID string) ([]Event, error) {
+func findHeadEventID(baseURL string, wellknownURL string) (string, error) {
 	resp, err := queryForEvent(baseURL + wellknownURL)
 
 	if errors.Is(err, ErrEventNotFound) {
-		return []Event{}, nil
+		return "", nil
 	}
 
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+
+	return resp.Data.EventID, nil
+}
+
+// findLatestEvents walks back from the head of the stream until it reaches
+// latestEventID or, when since is set, the first event that occurred before it.
+// Along with the events, oldest first, it returns any gaps in the sequence of
+// the events it walked
+func findLatestEvents(
+	baseURL string,
+	wellknownURL string,
+	latestEventID string,
+	since time.Time,
+) ([]Event, []*SequenceGapError, error) {
+	resp, err := queryForEvent(baseURL + wellknownURL)
+
+	if errors.Is(err, ErrEventNotFound) {
+		return []Event{}, nil, nil
+	}
+
+	if err != nil {
+		return nil, nil, err
 	}
 
-	if resp.Data.EventID == latestEventID {
-		return []Event{}, nil
+	if resp.Data.EventID == latestEventID || resp.Data.OccurredAt.Before(since) {
+		return []Event{}, nil, nil
 	}
 
 	events := []Event{resp.Data}
+	var gaps []*SequenceGapError
+
+	if gap := sequenceGap(resp); gap != nil {
+		gaps = append(gaps, gap)
+	}
 
 	currentEventID := resp.Data.EventID
 
@@ -92,20 +369,28 @@ func findLatestEvents(baseURL string, wellknownURL string, latestEventID string)
 		resp, err = queryForEvent(baseURL + resp.Metadata["next"].Href)
 
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		currentEventID = resp.Data.EventID
 
+		if resp.Data.OccurredAt.Before(since) {
+			break
+		}
+
 		if currentEventID != latestEventID {
 			events = append(events, resp.Data)
+
+			if gap := sequenceGap(resp); gap != nil {
+				gaps = append(gaps, gap)
+			}
 		}
 	}
 
-	sort.Slice(events, func(i, j int) bool {
-		return j < i
-	})
+	reverse(events)
+
+	reverse(gaps)
 
-	return events, nil
+	return events, gaps, nil
 }
 
 func queryForEvent(eventURL string) (*Response, error) {
@@ -131,6 +416,11 @@ func queryForEvent(eventURL string) (*Response, error) {
 		return nil, err
 	}
 
+	// streams may only describe the schema at the top level of the response
+	if body.Data.Schema == "" {
+		body.Data.Schema = body.Schema
+	}
+
 	return &body, nil
 }
 
@@ -154,3 +444,15 @@ func (d *Duration) UnmarshalJSON(bytes []byte) error {
 	*d = Duration(dur)
 	return nil
 }
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// reverse turns a slice walked back from the head of the stream around, so
+// that it is oldest first
+func reverse[T any](s []T) {
+	for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
+		s[i], s[j] = s[j], s[i]
+	}
+}
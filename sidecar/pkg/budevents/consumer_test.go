@@ -0,0 +1,272 @@
+package budevents_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/thisisbud/backend-events-sidecar/internal/sidecartest"
+	"github.com/thisisbud/backend-events-sidecar/pkg/budevents"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConsumerDeliversEventsOldestFirst(t *testing.T) {
+	sidecar := sidecartest.New(t)
+	published := publishNumbered(t, sidecar.URL, 20, 1)
+	rec := newRecorder()
+
+	consumer, err := budevents.NewConsumer(func(ctx context.Context, events ...budevents.Event) error {
+		rec.record(events...)
+		return nil
+	}, listener(sidecar.URL))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = consumeUntil(t, consumer.WithHooks(rec), func() bool {
+		delivered, _ := rec.snapshot()
+		return len(delivered) == len(published)
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	delivered, checkpoints := rec.snapshot()
+
+	for i, event := range delivered {
+		if n := number(t, event); n != i {
+			t.Fatalf("event [%d] delivered at position [%d]", n, i)
+		}
+	}
+
+	if last := checkpoints[len(checkpoints)-1]; last != published[len(published)-1].EventID {
+		t.Errorf("checkpoint is [%s], want the last event [%s]", last, published[len(published)-1].EventID)
+	}
+}
+
+func TestPartitionedConsumerKeepsOrderWithinKeys(t *testing.T) {
+	sidecar := sidecartest.New(t)
+	published := publishNumbered(t, sidecar.URL, 20, 3)
+	rec := newRecorder()
+
+	consumer, err := budevents.NewConsumer(func(ctx context.Context, events ...budevents.Event) error {
+		rec.record(events...)
+		return nil
+	}, listener(sidecar.URL))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	consumer = consumer.WithPartitioning(budevents.PayloadKey("k"), 4).WithHooks(rec)
+	err = consumeUntil(t, consumer, func() bool {
+		delivered, _ := rec.snapshot()
+		return len(delivered) == len(published)
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	delivered, checkpoints := rec.snapshot()
+	last := map[string]int{}
+	key := budevents.PayloadKey("k")
+
+	for _, event := range delivered {
+		n := number(t, event)
+
+		if previous, ok := last[key(event)]; ok && previous > n {
+			t.Fatalf("event [%d] of key [%s] delivered after event [%d]", n, key(event), previous)
+		}
+
+		last[key(event)] = n
+	}
+
+	if last := checkpoints[len(checkpoints)-1]; last != published[len(published)-1].EventID {
+		t.Errorf("checkpoint is [%s], want the last event [%s]", last, published[len(published)-1].EventID)
+	}
+}
+
+func TestPartitionedConsumerCheckpointsBeforeTheFirstFailure(t *testing.T) {
+	sidecar := sidecartest.New(t)
+	published := publishNumbered(t, sidecar.URL, 20, 3)
+	rec := newRecorder()
+	failure := errors.New("failed")
+	const failAt = 10
+
+	consumer, err := budevents.NewConsumer(func(ctx context.Context, events ...budevents.Event) error {
+		for _, event := range events {
+			if number(t, event) == failAt {
+				return failure
+			}
+		}
+
+		rec.record(events...)
+		return nil
+	}, listener(sidecar.URL))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	consumer = consumer.WithPartitioning(budevents.PayloadKey("k"), 4).WithHooks(rec)
+	err = consumeUntil(t, consumer, func() bool { return false })
+
+	if !errors.Is(err, failure) {
+		t.Fatalf("consumer stopped with [%v], want [%v]", err, failure)
+	}
+
+	delivered, checkpoints := rec.snapshot()
+
+	if len(checkpoints) == 0 {
+		t.Fatal("nothing was checkpointed")
+	}
+
+	checkpoint := -1
+
+	for i, event := range published {
+		if event.EventID == checkpoints[len(checkpoints)-1] {
+			checkpoint = i
+		}
+	}
+
+	if checkpoint != failAt-1 {
+		t.Fatalf("checkpointed event [%d], want [%d]", checkpoint, failAt-1)
+	}
+
+	seen := map[int]bool{}
+
+	for _, event := range delivered {
+		seen[number(t, event)] = true
+	}
+
+	for n := 0; n <= checkpoint; n++ {
+		if !seen[n] {
+			t.Errorf("event [%d] is checkpointed but was never processed", n)
+		}
+	}
+}
+
+func TestBatchedCallbackCorrelatesWithHandleEach(t *testing.T) {
+	sidecar := sidecartest.New(t)
+	published := publishNumbered(t, sidecar.URL, 5, 1)
+	publisher := budevents.NewPublisher(sidecar.URL)
+	mu := new(sync.Mutex)
+	var followUps []budevents.Event
+
+	consumer, err := budevents.NewConsumer(func(ctx context.Context, events ...budevents.Event) error {
+		return budevents.HandleEach(ctx, events, func(ctx context.Context, event budevents.Event) error {
+			if event.EventName != "numbered" {
+				return nil
+			}
+
+			resp, err := publisher.Publish(ctx, budevents.Event{
+				EventName: "followed_up",
+				Payload:   json.RawMessage(`{}`),
+			})
+
+			if err != nil {
+				return err
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			followUps = append(followUps, resp.Data)
+			return nil
+		})
+	}, listener(sidecar.URL))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = consumeUntil(t, consumer, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return len(followUps) == len(published)
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, followUp := range followUps {
+		if followUp.CausationID != published[i].EventID || followUp.CorrelationID != published[i].CorrelationID {
+			t.Errorf("follow up [%d] has causation [%s] and correlation [%s], want [%s] and [%s]",
+				i, followUp.CausationID, followUp.CorrelationID, published[i].EventID, published[i].CorrelationID)
+		}
+	}
+}
+
+// errorRecorder records the errors hooks are notified of
+type errorRecorder struct {
+	budevents.NopHooks
+	mu   *sync.Mutex
+	errs []error
+}
+
+func (rec *errorRecorder) OnError(ctx context.Context, conf budevents.Listener, err error) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	rec.errs = append(rec.errs, err)
+}
+
+func TestConsumerReportsErrorsStartingListeners(t *testing.T) {
+	tests := []struct {
+		name string
+		conf func(baseURL string) budevents.Listener
+	}{
+		{"discovering the path", func(baseURL string) budevents.Listener {
+			return listener(baseURL)
+		}},
+		{"finding the latest event", func(baseURL string) budevents.Listener {
+			conf := listener(baseURL)
+			conf.WellKnownPath = "/v1/events"
+			conf.StartPosition = budevents.StartFromLatest
+			return conf
+		}},
+		{"finding the event at a point in time", func(baseURL string) budevents.Listener {
+			conf := listener(baseURL)
+			conf.WellKnownPath = "/v1/events"
+			conf.StartPosition = budevents.StartFromPointInTime
+			conf.StartTime = time.Now()
+			return conf
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sidecar := sidecartest.New(t)
+			sidecar.Close()
+			rec := &errorRecorder{mu: new(sync.Mutex)}
+
+			consumer, err := budevents.NewConsumer(func(ctx context.Context, events ...budevents.Event) error {
+				return nil
+			}, test.conf(sidecar.URL))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			consumer = consumer.WithHooks(rec)
+
+			if err := consumeUntil(t, consumer, func() bool { return false }); err == nil {
+				t.Fatal("consumed without a sidecar, want an error")
+			}
+
+			if len(rec.errs) != 1 {
+				t.Errorf("hooks were notified of %v, want the error starting the listener", rec.errs)
+			}
+
+			if metrics := consumer.Metrics(); len(metrics) != 1 || metrics[0].Errors != 1 {
+				t.Errorf("metrics are %+v, want one error", metrics)
+			}
+		})
+	}
+}
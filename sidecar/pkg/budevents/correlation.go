@@ -0,0 +1,60 @@
+package budevents
+
+import "context"
+
+type causeKey struct{}
+
+// ContextWithCause records the event being handled, so that events published
+// with the context are correlated with it. The Router, partitioned consumers
+// and consumers polling a single event do this for their callbacks, and
+// callbacks given a batch of events must do it with HandleEach
+func ContextWithCause(ctx context.Context, event Event) context.Context {
+	return context.WithValue(ctx, causeKey{}, event)
+}
+
+// HandleEach calls the handler for each event in turn with the event as the
+// cause in its context, stopping at the first error. Callbacks given several
+// events at once use it so that what they publish is correlated per event
+func HandleEach(ctx context.Context, events []Event, handler Handler) error {
+	for _, event := range events {
+		if err := handler(ContextWithCause(ctx, event), event); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func CauseFromContext(ctx context.Context) (Event, bool) {
+	event, ok := ctx.Value(causeKey{}).(Event)
+	return event, ok
+}
+
+// Correlate fills in the correlation and causation IDs of an event from the
+// event being handled in ctx. Events published outside of handling another
+// start a correlation of their own, so need an ID first
+func Correlate(ctx context.Context, event Event) Event {
+	cause, ok := CauseFromContext(ctx)
+
+	if !ok {
+		if event.CorrelationID == "" {
+			event.CorrelationID = event.EventID
+		}
+
+		return event
+	}
+
+	if event.CausationID == "" {
+		event.CausationID = cause.EventID
+	}
+
+	if event.CorrelationID == "" {
+		event.CorrelationID = cause.CorrelationID
+	}
+
+	if event.CorrelationID == "" {
+		event.CorrelationID = cause.EventID
+	}
+
+	return event
+}
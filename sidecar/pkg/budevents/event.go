@@ -12,14 +12,62 @@ type Event struct {
 	EventName  string          `json:"event_name"`
 	OccurredAt time.Time       `json:"occurred_at"`
 	Payload    json.RawMessage `json:"payload"`
+	// Schema is the URL of the schema describing the payload
+	Schema        string `json:"schema,omitempty"`
+	SchemaVersion int    `json:"schema_version,omitempty"`
+
+	// CorrelationID is shared by every event resulting from the same original
+	// event, and CausationID is the ID of the event that directly caused this
+	// one. Both are filled in when publishing while handling another event
+	CorrelationID string `json:"correlation_id,omitempty"`
+	CausationID   string `json:"causation_id,omitempty"`
+	// Producer names the service that published the event
+	Producer string `json:"producer,omitempty"`
+	// Subject is the ID of the entity, or aggregate, the event is about
+	Subject string `json:"subject,omitempty"`
+	// Sequence is the position of the event in its stream, assigned by the
+	// sidecar counting up from 1 without gaps
+	Sequence int64 `json:"sequence,omitempty"`
 }
 
 type Response struct {
+	Schema   string               `json:"schema,omitempty"`
 	Data     Event                `json:"data"`
 	Metadata map[string]Reference `json:"metadata"`
 }
 
+// BatchRequest publishes several events atomically, in order
+type BatchRequest struct {
+	Events                  []Event `json:"events"`
+	ExpectedPreviousEventID string  `json:"expected_previous_event_id,omitempty"`
+}
+
+// BatchResponse holds the self links of the events in a BatchRequest, in the
+// same order
+type BatchResponse struct {
+	Events []Reference `json:"events"`
+}
+
 type Reference struct {
 	Href string `json:"href"`
 	Type string `json:"type"`
+	// Sequence is set on links to events, numbering them within the stream
+	// being read, which for a subject's stream differs from Event.Sequence
+	Sequence int64 `json:"sequence,omitempty"`
+}
+
+// Discovery is served from the root of a stream, linking to its latest event
+type Discovery struct {
+	Metadata map[string]Reference `json:"metadata"`
+}
+
+// SchemaIndex lists every version of the schemas registered with the sidecar
+type SchemaIndex struct {
+	Schemas []SchemaReference `json:"schemas"`
+}
+
+type SchemaReference struct {
+	EventName string `json:"event_name"`
+	Version   int    `json:"version"`
+	Href      string `json:"href"`
 }
@@ -0,0 +1,85 @@
+package budevents
+
+import (
+	"context"
+	"time"
+)
+
+// Callback handles the events of a poll. A poll of one event has it as the
+// cause in ctx, but a batch has no single cause, so callbacks that publish
+// while handling a batch go through HandleEach to keep them correlated
+type Callback func(ctx context.Context, events ...Event) error
+
+// Middleware wraps a callback in the same way http.Handler middleware wraps a
+// handler, so that cross-cutting concerns can be layered around it
+type Middleware func(next Callback) Callback
+
+// Hooks are notified of each stage of consuming from a listener. Embed
+// NopHooks to only implement the stages you are interested in
+type Hooks interface {
+	OnPollStart(ctx context.Context, listener Listener)
+	OnPollComplete(ctx context.Context, listener Listener, events []Event, latency time.Duration)
+	// OnEventsDelivered is called once the callback returns, with how many of
+	// the events (from the oldest) it processed successfully
+	OnEventsDelivered(ctx context.Context, listener Listener, events []Event, processed int, latency time.Duration)
+	OnError(ctx context.Context, listener Listener, err error)
+	OnCheckpoint(ctx context.Context, listener Listener, eventID string)
+}
+
+type NopHooks struct{}
+
+func (NopHooks) OnPollStart(ctx context.Context, listener Listener) {}
+
+func (NopHooks) OnPollComplete(ctx context.Context, listener Listener, events []Event, latency time.Duration) {
+}
+
+func (NopHooks) OnEventsDelivered(
+	ctx context.Context,
+	listener Listener,
+	events []Event,
+	processed int,
+	latency time.Duration,
+) {
+}
+
+func (NopHooks) OnError(ctx context.Context, listener Listener, err error) {}
+
+func (NopHooks) OnCheckpoint(ctx context.Context, listener Listener, eventID string) {}
+
+// WithHooks registers hooks that are called, in order, at each stage of
+// consuming from every listener
+func (consumer Consumer) WithHooks(hooks ...Hooks) Consumer {
+	consumer.hooks = append(append([]Hooks{}, consumer.hooks...), hooks...)
+	return consumer
+}
+
+// Use wraps the callback in middleware. The first middleware given is the
+// outermost, and sees the events first
+func (consumer Consumer) Use(middleware ...Middleware) Consumer {
+	consumer.middleware = append(append([]Middleware{}, consumer.middleware...), middleware...)
+	return consumer
+}
+
+func (consumer Consumer) handler() Callback {
+	callback := consumer.callback
+
+	for i := len(consumer.middleware) - 1; i >= 0; i-- {
+		callback = consumer.middleware[i](callback)
+	}
+
+	if len(consumer.upcasters) > 0 {
+		callback = upcast(consumer.upcasters, callback)
+	}
+
+	if consumer.inbox != nil {
+		callback = deduplicate(consumer.inbox, callback)
+	}
+
+	return callback
+}
+
+func (consumer Consumer) notify(fn func(hooks Hooks)) {
+	for _, hooks := range consumer.hooks {
+		fn(hooks)
+	}
+}
@@ -0,0 +1,455 @@
+package budevents
+
+import (
+	"bufio"
+	"container/list"
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Inbox records the IDs of events that have already been processed, so that
+// events delivered more than once (e.g. after a crash between the callback
+// and the checkpoint) are not handed to the callback again
+type Inbox interface {
+	Seen(ctx context.Context, eventID string) (bool, error)
+	Record(ctx context.Context, eventIDs ...string) error
+}
+
+// WithInbox filters out events that the inbox has already seen before they
+// reach the callback, and records those the callback processes successfully
+func (consumer Consumer) WithInbox(inbox Inbox) Consumer {
+	consumer.inbox = inbox
+	return consumer
+}
+
+func deduplicate(inbox Inbox, callback Callback) Callback {
+	return func(ctx context.Context, events ...Event) error {
+		if len(events) == 0 {
+			return callback(ctx, events...)
+		}
+
+		unseen := make([]Event, 0, len(events))
+		eventIDs := make([]string, 0, len(events))
+
+		for _, event := range events {
+			seen, err := inbox.Seen(ctx, event.EventID)
+
+			if err != nil {
+				return err
+			}
+
+			if !seen {
+				unseen = append(unseen, event)
+				eventIDs = append(eventIDs, event.EventID)
+			}
+		}
+
+		if len(unseen) == 0 {
+			return nil
+		}
+
+		if err := callback(ctx, unseen...); err != nil {
+			return err
+		}
+
+		return inbox.Record(ctx, eventIDs...)
+	}
+}
+
+// MemoryInbox keeps at most capacity event IDs, evicting the least recently
+// recorded ones first, and drops IDs older than the retention as it records
+// more. A zero retention keeps IDs until they are evicted
+type MemoryInbox struct {
+	mu        *sync.Mutex
+	capacity  int
+	retention time.Duration
+	order     *list.List
+	entries   map[string]*list.Element
+}
+
+type inboxEntry struct {
+	eventID    string
+	recordedAt time.Time
+}
+
+func NewMemoryInbox(capacity int, retention time.Duration) *MemoryInbox {
+	return &MemoryInbox{
+		mu:        new(sync.Mutex),
+		capacity:  capacity,
+		retention: retention,
+		order:     list.New(),
+		entries:   map[string]*list.Element{},
+	}
+}
+
+func (inbox *MemoryInbox) Seen(ctx context.Context, eventID string) (bool, error) {
+	inbox.mu.Lock()
+	defer inbox.mu.Unlock()
+
+	elem, ok := inbox.entries[eventID]
+
+	if !ok {
+		return false, nil
+	}
+
+	if expired(elem.Value.(inboxEntry).recordedAt, inbox.retention) {
+		inbox.order.Remove(elem)
+		delete(inbox.entries, eventID)
+		return false, nil
+	}
+
+	return true, nil
+}
+
+func (inbox *MemoryInbox) Record(ctx context.Context, eventIDs ...string) error {
+	inbox.mu.Lock()
+	defer inbox.mu.Unlock()
+
+	for _, eventID := range eventIDs {
+		inbox.record(inboxEntry{eventID: eventID, recordedAt: time.Now()})
+	}
+
+	return nil
+}
+
+func (inbox *MemoryInbox) record(entry inboxEntry) {
+	if elem, ok := inbox.entries[entry.eventID]; ok {
+		inbox.order.Remove(elem)
+	}
+
+	inbox.entries[entry.eventID] = inbox.order.PushFront(entry)
+
+	// entries are ordered by when they were recorded, so the expired ones are
+	// all at the back
+	for oldest := inbox.order.Back(); oldest != nil; oldest = inbox.order.Back() {
+		full := inbox.capacity > 0 && inbox.order.Len() > inbox.capacity
+
+		if !full && !expired(oldest.Value.(inboxEntry).recordedAt, inbox.retention) {
+			break
+		}
+
+		inbox.order.Remove(oldest)
+		delete(inbox.entries, oldest.Value.(inboxEntry).eventID)
+	}
+}
+
+func (inbox *MemoryInbox) len() int {
+	inbox.mu.Lock()
+	defer inbox.mu.Unlock()
+
+	return inbox.order.Len()
+}
+
+// minInboxCompaction is how many lines of a FileInbox must be expired or
+// re-recorded before it is rewritten
+const minInboxCompaction = 1024
+
+// FileInbox persists event IDs to an append-only file, one per line. The file
+// is rewritten without expired entries when it is opened, and while recording
+// once at least half of its lines are expired
+type FileInbox struct {
+	memory   *MemoryInbox
+	mu       *sync.Mutex
+	filename string
+	file     *os.File
+	// lines counts the lines in the file, some of which the memory inbox may
+	// have dropped
+	lines int
+}
+
+func NewFileInbox(filename string, retention time.Duration) (*FileInbox, error) {
+	inbox := &FileInbox{
+		memory:   NewMemoryInbox(0, retention),
+		mu:       new(sync.Mutex),
+		filename: filename,
+	}
+
+	if err := loadInboxFile(filename, inbox.memory); err != nil {
+		return nil, err
+	}
+
+	if err := inbox.compact(); err != nil {
+		return nil, err
+	}
+
+	return inbox, nil
+}
+
+func (inbox *FileInbox) Seen(ctx context.Context, eventID string) (bool, error) {
+	return inbox.memory.Seen(ctx, eventID)
+}
+
+func (inbox *FileInbox) Record(ctx context.Context, eventIDs ...string) error {
+	inbox.mu.Lock()
+	defer inbox.mu.Unlock()
+
+	now := time.Now()
+	var lines strings.Builder
+
+	for _, eventID := range eventIDs {
+		lines.WriteString(formatInboxLine(inboxEntry{eventID: eventID, recordedAt: now}))
+	}
+
+	if _, err := inbox.file.WriteString(lines.String()); err != nil {
+		return err
+	}
+
+	if err := inbox.file.Sync(); err != nil {
+		return err
+	}
+
+	inbox.lines += len(eventIDs)
+
+	if err := inbox.memory.Record(ctx, eventIDs...); err != nil {
+		return err
+	}
+
+	if dropped := inbox.lines - inbox.memory.len(); dropped >= minInboxCompaction && dropped >= inbox.lines/2 {
+		return inbox.compact()
+	}
+
+	return nil
+}
+
+func (inbox *FileInbox) Close() error {
+	inbox.mu.Lock()
+	defer inbox.mu.Unlock()
+
+	return inbox.file.Close()
+}
+
+// compact rewrites the file with only the entries the memory inbox holds, and
+// reopens it for appending. The caller holds mu, or has not shared the inbox
+func (inbox *FileInbox) compact() error {
+	lines, err := compactInboxFile(inbox.filename, inbox.memory)
+
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(inbox.filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+
+	if err != nil {
+		return err
+	}
+
+	if inbox.file != nil {
+		inbox.file.Close()
+	}
+
+	inbox.file = file
+	inbox.lines = lines
+	return nil
+}
+
+func loadInboxFile(filename string, memory *MemoryInbox) error {
+	file, err := os.Open(filename)
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		recordedAt, eventID, ok := strings.Cut(scanner.Text(), " ")
+
+		if !ok {
+			continue
+		}
+
+		nanos, err := strconv.ParseInt(recordedAt, 10, 64)
+
+		if err != nil {
+			return fmt.Errorf("malformed inbox entry [%s]", scanner.Text())
+		}
+
+		entry := inboxEntry{eventID: eventID, recordedAt: time.Unix(0, nanos)}
+
+		if !expired(entry.recordedAt, memory.retention) {
+			memory.record(entry)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// compactInboxFile replaces the file with the entries of the memory inbox,
+// oldest first, returning how many lines it wrote
+func compactInboxFile(filename string, memory *MemoryInbox) (int, error) {
+	memory.mu.Lock()
+	defer memory.mu.Unlock()
+
+	tmp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*")
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	writer := bufio.NewWriter(tmp)
+
+	for elem := memory.order.Back(); elem != nil; elem = elem.Prev() {
+		if _, err := writer.WriteString(formatInboxLine(elem.Value.(inboxEntry))); err != nil {
+			tmp.Close()
+			return 0, err
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+
+	return memory.order.Len(), os.Rename(tmp.Name(), filename)
+}
+
+func formatInboxLine(entry inboxEntry) string {
+	return strconv.FormatInt(entry.recordedAt.UnixNano(), 10) + " " + entry.eventID + "\n"
+}
+
+// SQLPlaceholder renders the nth (1-indexed) bind parameter of a query, which
+// differs between database drivers
+type SQLPlaceholder func(n int) string
+
+func QuestionPlaceholder(n int) string {
+	return "?"
+}
+
+func DollarPlaceholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+// SQLInbox stores event IDs in a table, created by CreateTable. The table is
+// not pruned automatically: call Prune periodically to drop expired entries
+type SQLInbox struct {
+	db          *sql.DB
+	table       string
+	retention   time.Duration
+	placeholder SQLPlaceholder
+}
+
+func NewSQLInbox(db *sql.DB, table string, retention time.Duration, placeholder SQLPlaceholder) *SQLInbox {
+	return &SQLInbox{
+		db:          db,
+		table:       table,
+		retention:   retention,
+		placeholder: placeholder,
+	}
+}
+
+func (inbox *SQLInbox) CreateTable(ctx context.Context) error {
+	_, err := inbox.db.ExecContext(ctx, fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s (event_id VARCHAR(255) NOT NULL PRIMARY KEY, recorded_at BIGINT NOT NULL)",
+		inbox.table,
+	))
+
+	return err
+}
+
+func (inbox *SQLInbox) Seen(ctx context.Context, eventID string) (bool, error) {
+	var count int
+
+	err := inbox.db.QueryRowContext(ctx, fmt.Sprintf(
+		"SELECT COUNT(*) FROM %s WHERE event_id = %s AND recorded_at >= %s",
+		inbox.table,
+		inbox.placeholder(1),
+		inbox.placeholder(2),
+	), eventID, inbox.cutoff()).Scan(&count)
+
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+func (inbox *SQLInbox) Record(ctx context.Context, eventIDs ...string) error {
+	tx, err := inbox.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	now := time.Now().UnixNano()
+
+	for _, eventID := range eventIDs {
+		result, err := tx.ExecContext(ctx, fmt.Sprintf(
+			"UPDATE %s SET recorded_at = %s WHERE event_id = %s",
+			inbox.table,
+			inbox.placeholder(1),
+			inbox.placeholder(2),
+		), now, eventID)
+
+		if err != nil {
+			return err
+		}
+
+		updated, err := result.RowsAffected()
+
+		if err != nil {
+			return err
+		}
+
+		if updated > 0 {
+			continue
+		}
+
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(
+			"INSERT INTO %s (event_id, recorded_at) VALUES (%s, %s)",
+			inbox.table,
+			inbox.placeholder(1),
+			inbox.placeholder(2),
+		), eventID, now); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (inbox *SQLInbox) Prune(ctx context.Context) error {
+	if inbox.retention <= 0 {
+		return nil
+	}
+
+	_, err := inbox.db.ExecContext(ctx, fmt.Sprintf(
+		"DELETE FROM %s WHERE recorded_at < %s",
+		inbox.table,
+		inbox.placeholder(1),
+	), inbox.cutoff())
+
+	return err
+}
+
+func (inbox *SQLInbox) cutoff() int64 {
+	if inbox.retention <= 0 {
+		return 0
+	}
+
+	return time.Now().Add(-inbox.retention).UnixNano()
+}
+
+func expired(recordedAt time.Time, retention time.Duration) bool {
+	return retention > 0 && time.Since(recordedAt) > retention
+}
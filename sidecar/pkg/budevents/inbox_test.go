@@ -0,0 +1,195 @@
+package budevents_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/thisisbud/backend-events-sidecar/internal/sidecartest"
+	"github.com/thisisbud/backend-events-sidecar/pkg/budevents"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func assertSeen(t *testing.T, inbox budevents.Inbox, want map[string]bool) {
+	t.Helper()
+
+	for eventID, wantSeen := range want {
+		seen, err := inbox.Seen(context.Background(), eventID)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if seen != wantSeen {
+			t.Errorf("seen [%s] is [%t], want [%t]", eventID, seen, wantSeen)
+		}
+	}
+}
+
+func record(t *testing.T, inbox budevents.Inbox, eventIDs ...string) {
+	t.Helper()
+
+	if err := inbox.Record(context.Background(), eventIDs...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemoryInboxEvictsLeastRecentlyRecorded(t *testing.T) {
+	inbox := budevents.NewMemoryInbox(2, 0)
+
+	record(t, inbox, "a", "b")
+	// recording a again makes b the least recent
+	record(t, inbox, "a")
+	record(t, inbox, "c")
+
+	assertSeen(t, inbox, map[string]bool{"a": true, "b": false, "c": true, "d": false})
+}
+
+func TestMemoryInboxForgetsExpiredIDs(t *testing.T) {
+	inbox := budevents.NewMemoryInbox(0, 20*time.Millisecond)
+
+	record(t, inbox, "a")
+	time.Sleep(40 * time.Millisecond)
+	record(t, inbox, "b")
+
+	assertSeen(t, inbox, map[string]bool{"a": false, "b": true})
+}
+
+func TestFileInboxReloads(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "inbox")
+	inbox, err := budevents.NewFileInbox(filename, time.Hour)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record(t, inbox, "a", "b")
+	record(t, inbox, "a")
+	inbox.Close()
+
+	reopened, err := budevents.NewFileInbox(filename, time.Hour)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer reopened.Close()
+
+	assertSeen(t, reopened, map[string]bool{"a": true, "b": true, "c": false})
+
+	// reopening drops the line a was first recorded on
+	if lines := countLines(t, filename); lines != 2 {
+		t.Errorf("file has [%d] lines after reopening, want [2]", lines)
+	}
+}
+
+func TestFileInboxDropsExpiredIDsOnReload(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "inbox")
+	inbox, err := budevents.NewFileInbox(filename, 20*time.Millisecond)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record(t, inbox, "a")
+	inbox.Close()
+	time.Sleep(40 * time.Millisecond)
+
+	reopened, err := budevents.NewFileInbox(filename, 20*time.Millisecond)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer reopened.Close()
+
+	assertSeen(t, reopened, map[string]bool{"a": false})
+
+	if lines := countLines(t, filename); lines != 0 {
+		t.Errorf("file has [%d] lines after reopening, want none", lines)
+	}
+}
+
+func TestFileInboxCompactsWhileRecording(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "inbox")
+	inbox, err := budevents.NewFileInbox(filename, 20*time.Millisecond)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer inbox.Close()
+
+	eventIDs := make([]string, 2000)
+
+	for i := range eventIDs {
+		eventIDs[i] = fmt.Sprintf("e%d", i)
+	}
+
+	record(t, inbox, eventIDs...)
+	time.Sleep(40 * time.Millisecond)
+	record(t, inbox, "last")
+
+	if lines := countLines(t, filename); lines != 1 {
+		t.Errorf("file has [%d] lines once the rest expired, want [1]", lines)
+	}
+
+	// the inbox keeps appending to the compacted file
+	record(t, inbox, "after")
+
+	if lines := countLines(t, filename); lines != 2 {
+		t.Errorf("file has [%d] lines after recording again, want [2]", lines)
+	}
+
+	assertSeen(t, inbox, map[string]bool{"e0": false, "last": true, "after": true})
+}
+
+func TestConsumerSkipsEventsTheInboxHasSeen(t *testing.T) {
+	sidecar := sidecartest.New(t)
+	published := publishNumbered(t, sidecar.URL, 5, 1)
+	rec := newRecorder()
+	inbox := budevents.NewMemoryInbox(0, 0)
+
+	record(t, inbox, published[0].EventID, published[1].EventID)
+
+	consumer, err := budevents.NewConsumer(func(ctx context.Context, events ...budevents.Event) error {
+		rec.record(events...)
+		return nil
+	}, listener(sidecar.URL))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = consumeUntil(t, consumer.WithInbox(inbox).WithHooks(rec), func() bool {
+		_, checkpoints := rec.snapshot()
+		return len(checkpoints) > 0 && checkpoints[len(checkpoints)-1] == published[len(published)-1].EventID
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	delivered, _ := rec.snapshot()
+
+	if len(delivered) != 3 || number(t, delivered[0]) != 2 {
+		t.Errorf("delivered [%d] events, want the [3] the inbox had not seen", len(delivered))
+	}
+
+	// delivered events are recorded
+	assertSeen(t, inbox, map[string]bool{published[4].EventID: true})
+}
+
+func countLines(t *testing.T, filename string) int {
+	t.Helper()
+
+	blob, err := os.ReadFile(filename)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return bytes.Count(blob, []byte("\n"))
+}
@@ -0,0 +1,175 @@
+package budevents
+
+import (
+	"context"
+	"reflect"
+	"sync"
+)
+
+// listenerSet holds the listeners a consumer should be running, and starts and
+// stops them as the set changes while the consumer is running. It is
+// registered as hooks so that it knows the checkpoint of every listener
+type listenerSet struct {
+	NopHooks
+	mu *sync.Mutex
+	// changing serialises changes to the set, which wait for stopped listeners
+	// without holding mu, since they may checkpoint on their way out
+	changing    *sync.Mutex
+	configs     map[string]Listener
+	running     map[string]func()
+	checkpoints map[string]string
+	start       func(conf Listener) func()
+}
+
+func newListenerSet(listeners []Listener) *listenerSet {
+	set := &listenerSet{
+		mu:          new(sync.Mutex),
+		changing:    new(sync.Mutex),
+		configs:     map[string]Listener{},
+		running:     map[string]func(){},
+		checkpoints: map[string]string{},
+	}
+
+	for _, conf := range listeners {
+		set.configs[conf.key()] = conf
+	}
+
+	return set
+}
+
+// SetListeners replaces the listeners of the consumer. While it is consuming,
+// new listeners are started and removed ones stopped. Listeners whose config
+// is unchanged keep running, and changed ones are restarted from their last
+// checkpoint unless their start position was changed too. A changed listener
+// is only restarted once it has finished what it was doing, so this must not
+// be called from the callback of a listener it changes
+func (consumer Consumer) SetListeners(listeners ...Listener) error {
+	if err := validateListeners(listeners); err != nil {
+		return err
+	}
+
+	consumer.listeners.set(listeners)
+	return nil
+}
+
+func (set *listenerSet) set(listeners []Listener) {
+	set.changing.Lock()
+	defer set.changing.Unlock()
+
+	configs := map[string]Listener{}
+
+	for _, conf := range listeners {
+		configs[conf.key()] = conf
+	}
+
+	set.mu.Lock()
+	var changed []string
+
+	for key, previous := range set.configs {
+		if conf, ok := configs[key]; !ok || !reflect.DeepEqual(conf, previous) {
+			changed = append(changed, key)
+		}
+	}
+
+	stopped := set.detach(changed)
+	set.mu.Unlock()
+
+	for _, stop := range stopped {
+		stop()
+	}
+
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	for _, key := range changed {
+		if conf, ok := configs[key]; !ok || !sameStartPosition(conf, set.configs[key]) {
+			delete(set.checkpoints, key)
+		}
+	}
+
+	set.configs = configs
+
+	if set.start == nil {
+		return
+	}
+
+	for key, conf := range set.configs {
+		if _, ok := set.running[key]; !ok {
+			set.startListener(key, conf)
+		}
+	}
+}
+
+func (set *listenerSet) run(start func(conf Listener) func()) {
+	set.changing.Lock()
+	defer set.changing.Unlock()
+
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	set.start = start
+
+	for key, conf := range set.configs {
+		set.startListener(key, conf)
+	}
+}
+
+// stop stops every listener, waiting for them to finish
+func (set *listenerSet) stop() {
+	set.changing.Lock()
+	defer set.changing.Unlock()
+
+	set.mu.Lock()
+	keys := make([]string, 0, len(set.running))
+
+	for key := range set.running {
+		keys = append(keys, key)
+	}
+
+	stopped := set.detach(keys)
+	set.start = nil
+	set.mu.Unlock()
+
+	for _, stop := range stopped {
+		stop()
+	}
+}
+
+func (set *listenerSet) startListener(key string, conf Listener) {
+	if checkpoint, ok := set.checkpoints[key]; ok {
+		conf.LastEventID = checkpoint
+		conf.StartPosition = StartFromEventID
+	}
+
+	set.running[key] = set.start(conf)
+}
+
+// detach takes the listeners out of the running set, returning the functions
+// that stop them. The caller holds mu
+func (set *listenerSet) detach(keys []string) []func() {
+	var stopped []func()
+
+	for _, key := range keys {
+		if stop, ok := set.running[key]; ok {
+			stopped = append(stopped, stop)
+			delete(set.running, key)
+		}
+	}
+
+	return stopped
+}
+
+func (set *listenerSet) OnCheckpoint(ctx context.Context, conf Listener, eventID string) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	if _, ok := set.configs[conf.key()]; ok {
+		set.checkpoints[conf.key()] = eventID
+	}
+}
+
+func sameStartPosition(a Listener, b Listener) bool {
+	return a.LastEventID == b.LastEventID &&
+		a.StartPosition == b.StartPosition &&
+		a.StartTime.Equal(b.StartTime)
+}
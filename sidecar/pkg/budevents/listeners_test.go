@@ -0,0 +1,85 @@
+package budevents_test
+
+import (
+	"context"
+	"github.com/thisisbud/backend-events-sidecar/internal/sidecartest"
+	"github.com/thisisbud/backend-events-sidecar/pkg/budevents"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReconfiguredListenerResumesOnceTheOldOneHasStopped(t *testing.T) {
+	sidecar := sidecartest.New(t)
+	published := publishNumbered(t, sidecar.URL, 5, 1)
+	rec := newRecorder()
+	conf := listener(sidecar.URL)
+
+	var inFlight int32
+	var overlapped int32
+	started := make(chan struct{}, 1)
+
+	consumer, err := budevents.NewConsumer(func(ctx context.Context, events ...budevents.Event) error {
+		if atomic.AddInt32(&inFlight, 1) > 1 {
+			atomic.StoreInt32(&overlapped, 1)
+		}
+
+		defer atomic.AddInt32(&inFlight, -1)
+
+		select {
+		case started <- struct{}{}:
+			// hold the first delivery while the listener is reconfigured
+			time.Sleep(100 * time.Millisecond)
+		default:
+		}
+
+		rec.record(events...)
+		return nil
+	}, conf)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		<-started
+		conf.Ticker = budevents.Duration(20 * time.Millisecond)
+
+		if err := consumer.SetListeners(conf); err != nil {
+			t.Error(err)
+		}
+
+		for i := 0; i < len(published); i++ {
+			if _, err := budevents.NewPublisher(sidecar.URL).Publish(context.Background(), budevents.Event{
+				EventName: "numbered",
+				Payload:   published[i].Payload,
+			}); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+
+	err = consumeUntil(t, consumer.WithHooks(rec), func() bool {
+		delivered, _ := rec.snapshot()
+		return len(delivered) >= 2*len(published)
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if atomic.LoadInt32(&overlapped) != 0 {
+		t.Error("the old and new listener delivered at the same time")
+	}
+
+	delivered, _ := rec.snapshot()
+	seen := map[string]bool{}
+
+	for _, event := range delivered {
+		if seen[event.EventID] {
+			t.Errorf("event [%s] was delivered twice", event.EventID)
+		}
+
+		seen[event.EventID] = true
+	}
+}
@@ -0,0 +1,208 @@
+package budevents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type ListenerMetrics struct {
+	Listener string `json:"listener"`
+	// EventsBehindHead is how many events the listener had yet to process
+	// when it last polled, between its checkpoint and the head of the stream
+	EventsBehindHead   int           `json:"events_behind_head"`
+	LastSuccessfulPoll time.Time     `json:"last_successful_poll"`
+	PollLatency        time.Duration `json:"poll_latency"`
+	CallbackLatency    time.Duration `json:"callback_latency"`
+	Errors             uint64        `json:"errors"`
+	SequenceGaps       uint64        `json:"sequence_gaps"`
+	// LastEventReceivedAt is when the listener last polled new events, rather
+	// than when those events occurred
+	LastEventReceivedAt time.Time `json:"last_event_received_at"`
+}
+
+// TimeSinceLastEvent is how long ago the listener last received a new event,
+// or zero if it has not received one yet
+func (m ListenerMetrics) TimeSinceLastEvent() time.Duration {
+	if m.LastEventReceivedAt.IsZero() {
+		return 0
+	}
+
+	return time.Since(m.LastEventReceivedAt)
+}
+
+// metrics is registered as hooks on every consumer
+type metrics struct {
+	NopHooks
+	mu        *sync.Mutex
+	listeners map[string]*ListenerMetrics
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		mu:        new(sync.Mutex),
+		listeners: map[string]*ListenerMetrics{},
+	}
+}
+
+func (m *metrics) update(conf Listener, fn func(lm *ListenerMetrics)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := conf.key()
+	lm, ok := m.listeners[key]
+
+	if !ok {
+		lm = &ListenerMetrics{Listener: key}
+		m.listeners[key] = lm
+	}
+
+	fn(lm)
+}
+
+func (m *metrics) OnPollComplete(ctx context.Context, conf Listener, events []Event, latency time.Duration) {
+	m.update(conf, func(lm *ListenerMetrics) {
+		lm.PollLatency = latency
+		lm.LastSuccessfulPoll = time.Now()
+		lm.EventsBehindHead = len(events)
+
+		if len(events) > 0 {
+			lm.LastEventReceivedAt = lm.LastSuccessfulPoll
+		}
+	})
+}
+
+func (m *metrics) OnEventsDelivered(
+	ctx context.Context,
+	conf Listener,
+	events []Event,
+	processed int,
+	latency time.Duration,
+) {
+	m.update(conf, func(lm *ListenerMetrics) {
+		lm.CallbackLatency = latency
+	})
+}
+
+func (m *metrics) OnError(ctx context.Context, conf Listener, err error) {
+	var gap *SequenceGapError
+
+	m.update(conf, func(lm *ListenerMetrics) {
+		lm.Errors++
+
+		if errors.As(err, &gap) {
+			lm.SequenceGaps++
+		}
+	})
+}
+
+func (m *metrics) snapshot() []ListenerMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot := make([]ListenerMetrics, 0, len(m.listeners))
+
+	for _, lm := range m.listeners {
+		snapshot = append(snapshot, *lm)
+	}
+
+	sort.Slice(snapshot, func(i, j int) bool {
+		return snapshot[i].Listener < snapshot[j].Listener
+	})
+
+	return snapshot
+}
+
+// Metrics returns the current metrics of every listener that has polled at
+// least once
+func (consumer Consumer) Metrics() []ListenerMetrics {
+	return consumer.metrics.snapshot()
+}
+
+// MetricsHandler renders the listener metrics in the Prometheus text
+// exposition format
+func (consumer Consumer) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		snapshot := consumer.Metrics()
+		var out strings.Builder
+
+		families := []struct {
+			name  string
+			help  string
+			kind  string
+			value func(lm ListenerMetrics) float64
+		}{
+			{
+				name:  "budevents_listener_events_behind_head",
+				help:  "Events between the checkpoint of the listener and the head of the stream at the last poll.",
+				kind:  "gauge",
+				value: func(lm ListenerMetrics) float64 { return float64(lm.EventsBehindHead) },
+			},
+			{
+				name:  "budevents_listener_last_successful_poll_timestamp_seconds",
+				help:  "Unix time of the last successful poll.",
+				kind:  "gauge",
+				value: func(lm ListenerMetrics) float64 { return unixSeconds(lm.LastSuccessfulPoll) },
+			},
+			{
+				name:  "budevents_listener_poll_duration_seconds",
+				help:  "Time taken by the last poll of the stream.",
+				kind:  "gauge",
+				value: func(lm ListenerMetrics) float64 { return lm.PollLatency.Seconds() },
+			},
+			{
+				name:  "budevents_listener_callback_duration_seconds",
+				help:  "Time taken by the callback to process the events of the last poll.",
+				kind:  "gauge",
+				value: func(lm ListenerMetrics) float64 { return lm.CallbackLatency.Seconds() },
+			},
+			{
+				name:  "budevents_listener_errors_total",
+				help:  "Errors encountered while polling or processing events.",
+				kind:  "counter",
+				value: func(lm ListenerMetrics) float64 { return float64(lm.Errors) },
+			},
+			{
+				name:  "budevents_listener_sequence_gaps_total",
+				help:  "Gaps found in the sequence numbers of the events received.",
+				kind:  "counter",
+				value: func(lm ListenerMetrics) float64 { return float64(lm.SequenceGaps) },
+			},
+			{
+				name:  "budevents_listener_seconds_since_last_event",
+				help:  "Time since the listener last received a new event.",
+				kind:  "gauge",
+				value: func(lm ListenerMetrics) float64 { return lm.TimeSinceLastEvent().Seconds() },
+			},
+		}
+
+		for _, family := range families {
+			fmt.Fprintf(&out, "# HELP %s %s\n", family.name, family.help)
+			fmt.Fprintf(&out, "# TYPE %s %s\n", family.name, family.kind)
+
+			for _, lm := range snapshot {
+				fmt.Fprintf(&out, "%s{listener=\"%s\"} %g\n", family.name, escapeLabel(lm.Listener), family.value(lm))
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_, _ = w.Write([]byte(out.String()))
+	})
+}
+
+func unixSeconds(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return float64(t.UnixNano()) / float64(time.Second)
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
@@ -0,0 +1,78 @@
+package budevents_test
+
+import (
+	"context"
+	"github.com/thisisbud/backend-events-sidecar/internal/sidecartest"
+	"github.com/thisisbud/backend-events-sidecar/pkg/budevents"
+	"sync"
+	"testing"
+	"time"
+)
+
+// metricsRecorder captures the consumer's metrics once events are delivered
+type metricsRecorder struct {
+	budevents.NopHooks
+	mu       *sync.Mutex
+	consumer *budevents.Consumer
+	captured []budevents.ListenerMetrics
+}
+
+func (rec *metricsRecorder) OnEventsDelivered(
+	ctx context.Context,
+	conf budevents.Listener,
+	events []budevents.Event,
+	processed int,
+	latency time.Duration,
+) {
+	if len(events) == 0 {
+		return
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	rec.captured = rec.consumer.Metrics()
+}
+
+func (rec *metricsRecorder) snapshot() []budevents.ListenerMetrics {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	return rec.captured
+}
+
+func TestMetricsReportHowFarBehindTheLastPollWas(t *testing.T) {
+	sidecar := sidecartest.New(t)
+	published := publishNumbered(t, sidecar.URL, 7, 1)
+
+	consumer, err := budevents.NewConsumer(func(ctx context.Context, events ...budevents.Event) error {
+		return nil
+	}, listener(sidecar.URL))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := &metricsRecorder{mu: new(sync.Mutex), consumer: &consumer}
+	consumer = consumer.WithHooks(rec)
+
+	err = consumeUntil(t, consumer, func() bool { return rec.snapshot() != nil })
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metrics := rec.snapshot()
+
+	if len(metrics) != 1 {
+		t.Fatalf("got metrics for [%d] listeners, want [1]", len(metrics))
+	}
+
+	if metrics[0].EventsBehindHead != len(published) {
+		t.Errorf("events behind head is [%d] once delivered, want the [%d] events of the poll", metrics[0].EventsBehindHead, len(published))
+	}
+
+	if metrics[0].LastEventReceivedAt.IsZero() {
+		t.Error("no time recorded for the events received")
+	}
+}
@@ -0,0 +1,169 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/thisisbud/backend-events-sidecar/pkg/budevents"
+	"time"
+)
+
+// Dialect covers the differences between databases that the outbox relies on
+type Dialect struct {
+	Placeholder budevents.SQLPlaceholder
+	// AutoIncrement is the column definition of the position column, which
+	// gives the order events were written in
+	AutoIncrement string
+}
+
+var (
+	SQLite = Dialect{
+		Placeholder:   budevents.QuestionPlaceholder,
+		AutoIncrement: "INTEGER PRIMARY KEY AUTOINCREMENT",
+	}
+	Postgres = Dialect{
+		Placeholder:   budevents.DollarPlaceholder,
+		AutoIncrement: "BIGSERIAL PRIMARY KEY",
+	}
+	MySQL = Dialect{
+		Placeholder:   budevents.QuestionPlaceholder,
+		AutoIncrement: "BIGINT AUTO_INCREMENT PRIMARY KEY",
+	}
+)
+
+// Outbox stores events in a table of the service's own database, written in
+// the same transaction as the business data they describe
+type Outbox struct {
+	table   string
+	dialect Dialect
+}
+
+func New(table string, dialect Dialect) Outbox {
+	return Outbox{
+		table:   table,
+		dialect: dialect,
+	}
+}
+
+func (outbox Outbox) CreateTable(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s ("+
+			"position %s, "+
+			"event_id VARCHAR(255) NOT NULL UNIQUE, "+
+			"event TEXT NOT NULL, "+
+			"created_at BIGINT NOT NULL, "+
+			"sent_at BIGINT NULL, "+
+			"failed_at BIGINT NULL, "+
+			"failure TEXT NULL"+
+			")",
+		outbox.table,
+		outbox.dialect.AutoIncrement,
+	))
+
+	return err
+}
+
+// Write adds events to the outbox as part of tx. Events are given their ID,
+// occurrence time and correlation here, so that every attempt to relay them
+// publishes an identical event
+func (outbox Outbox) Write(ctx context.Context, tx *sql.Tx, events ...budevents.Event) error {
+	for _, event := range events {
+		if event.EventID == "" {
+			event.EventID = uuid.NewString()
+		}
+
+		if event.OccurredAt.IsZero() {
+			event.OccurredAt = time.Now().UTC()
+		}
+
+		event = budevents.Correlate(ctx, event)
+		blob, err := json.Marshal(event)
+
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(
+			"INSERT INTO %s (event_id, event, created_at) VALUES (%s, %s, %s)",
+			outbox.table,
+			outbox.dialect.Placeholder(1),
+			outbox.dialect.Placeholder(2),
+			outbox.dialect.Placeholder(3),
+		), event.EventID, string(blob), time.Now().UnixNano()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type pendingEvent struct {
+	position  int64
+	createdAt time.Time
+	event     budevents.Event
+}
+
+func (outbox Outbox) pending(ctx context.Context, db *sql.DB, limit int) ([]pendingEvent, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(
+		"SELECT position, created_at, event FROM %s "+
+			"WHERE sent_at IS NULL AND failed_at IS NULL ORDER BY position LIMIT %d",
+		outbox.table,
+		limit,
+	))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var pending []pendingEvent
+
+	for rows.Next() {
+		var row pendingEvent
+		var createdAt int64
+		var blob string
+
+		if err := rows.Scan(&row.position, &createdAt, &blob); err != nil {
+			return nil, err
+		}
+
+		row.createdAt = time.Unix(0, createdAt)
+
+		if err := json.Unmarshal([]byte(blob), &row.event); err != nil {
+			return nil, err
+		}
+
+		pending = append(pending, row)
+	}
+
+	return pending, rows.Err()
+}
+
+func (outbox Outbox) markSent(ctx context.Context, db *sql.DB, position int64) error {
+	_, err := db.ExecContext(ctx, fmt.Sprintf(
+		"UPDATE %s SET sent_at = %s WHERE position = %s",
+		outbox.table,
+		outbox.dialect.Placeholder(1),
+		outbox.dialect.Placeholder(2),
+	), time.Now().UnixNano(), position)
+
+	return err
+}
+
+// markFailed sets aside an event the sidecar rejected, with why, so that it no
+// longer holds up the events after it. Failed events can be found by their
+// failed_at column, and relayed again by clearing it
+func (outbox Outbox) markFailed(ctx context.Context, db *sql.DB, position int64, failure error) error {
+	_, err := db.ExecContext(ctx, fmt.Sprintf(
+		"UPDATE %s SET failed_at = %s, failure = %s WHERE position = %s",
+		outbox.table,
+		outbox.dialect.Placeholder(1),
+		outbox.dialect.Placeholder(2),
+		outbox.dialect.Placeholder(3),
+	), time.Now().UnixNano(), failure.Error(), position)
+
+	return err
+}
@@ -0,0 +1,165 @@
+package outbox_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/thisisbud/backend-events-sidecar/internal/sidecartest"
+	"github.com/thisisbud/backend-events-sidecar/pkg/budevents"
+	"github.com/thisisbud/backend-events-sidecar/pkg/budevents/outbox"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func openOutbox(t *testing.T) (*sql.DB, outbox.Outbox) {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "outbox.db"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { db.Close() })
+
+	events := outbox.New("outbox", outbox.SQLite)
+
+	if err := events.CreateTable(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+
+	return db, events
+}
+
+func write(t *testing.T, db *sql.DB, events outbox.Outbox, eventNames ...string) {
+	t.Helper()
+
+	tx, err := db.Begin()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, eventName := range eventNames {
+		if err := events.Write(context.Background(), tx, budevents.Event{
+			EventName: eventName,
+			Payload:   json.RawMessage(`{}`),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRelayPublishesInOrderOnce(t *testing.T) {
+	sidecar := sidecartest.New(t)
+	db, events := openOutbox(t)
+	relay := outbox.NewRelay(db, events, budevents.NewPublisher(sidecar.URL)).WithVisibilityWindow(0)
+
+	write(t, db, events, "first", "second")
+	write(t, db, events, "third")
+
+	relayed, err := relay.RelayPending(context.Background())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if relayed != 3 {
+		t.Errorf("relayed [%d] events, want [3]", relayed)
+	}
+
+	// sent events are not relayed again
+	if relayed, err := relay.RelayPending(context.Background()); err != nil || relayed != 0 {
+		t.Errorf("relayed [%d] events again, with [%v]", relayed, err)
+	}
+
+	if names := sidecartest.StreamNames(t, sidecar.URL); !reflect.DeepEqual(names, []string{"first", "second", "third"}) {
+		t.Errorf("stream is %v, want the events in the order they were written", names)
+	}
+}
+
+func TestRelayWaitsForTheVisibilityWindow(t *testing.T) {
+	sidecar := sidecartest.New(t)
+	db, events := openOutbox(t)
+	relay := outbox.NewRelay(db, events, budevents.NewPublisher(sidecar.URL)).WithVisibilityWindow(time.Hour)
+
+	write(t, db, events, "first")
+
+	if relayed, err := relay.RelayPending(context.Background()); err != nil || relayed != 0 {
+		t.Fatalf("relayed [%d] events inside the window, with [%v]", relayed, err)
+	}
+
+	relay = relay.WithVisibilityWindow(0)
+
+	if relayed, err := relay.RelayPending(context.Background()); err != nil || relayed != 1 {
+		t.Fatalf("relayed [%d] events after the window, with [%v]", relayed, err)
+	}
+}
+
+func TestRelaySetsAsideRejectedEvents(t *testing.T) {
+	sidecar := sidecartest.New(t)
+	db, events := openOutbox(t)
+
+	var rejected []budevents.Event
+
+	relay := outbox.NewRelay(db, events, budevents.NewPublisher(sidecar.URL).WithRetries(0, 0)).
+		WithVisibilityWindow(0).
+		WithRejected(func(event budevents.Event, err error) {
+			rejected = append(rejected, event)
+		})
+
+	// event names must be snake_case, so the sidecar rejects the second
+	write(t, db, events, "first", "Not Snake Case", "third")
+
+	if _, err := relay.RelayPending(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rejected) != 1 || rejected[0].EventName != "Not Snake Case" {
+		t.Fatalf("rejected %v, want the badly named event", rejected)
+	}
+
+	if names := sidecartest.StreamNames(t, sidecar.URL); !reflect.DeepEqual(names, []string{"first", "third"}) {
+		t.Errorf("stream is %v, want the events either side of the rejected one", names)
+	}
+
+	var failure string
+
+	if err := db.QueryRow("SELECT failure FROM outbox WHERE failed_at IS NOT NULL").Scan(&failure); err != nil {
+		t.Fatal(err)
+	}
+
+	if failure == "" {
+		t.Error("the failed event was stored without why")
+	}
+}
+
+func TestRelayStopsWhileTheSidecarIsUnreachable(t *testing.T) {
+	sidecar := sidecartest.New(t)
+	db, events := openOutbox(t)
+	relay := outbox.NewRelay(db, events, budevents.NewPublisher(sidecar.URL).WithRetries(0, 0)).WithVisibilityWindow(0)
+
+	write(t, db, events, "first")
+	sidecar.Close()
+
+	if _, err := relay.RelayPending(context.Background()); err == nil {
+		t.Fatal("relayed without a sidecar, want an error")
+	}
+
+	var pending int
+
+	if err := db.QueryRow("SELECT COUNT(*) FROM outbox WHERE sent_at IS NULL AND failed_at IS NULL").Scan(&pending); err != nil {
+		t.Fatal(err)
+	}
+
+	if pending != 1 {
+		t.Errorf("[%d] events pending, want the event kept for the next attempt", pending)
+	}
+}
@@ -0,0 +1,137 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"github.com/thisisbud/backend-events-sidecar/pkg/budevents"
+	"time"
+)
+
+// Relay publishes the events of an outbox to the sidecar in the order they
+// were written, marking each as sent once the sidecar has accepted it. An
+// event can be published again if the relay stops between the two, so only
+// one relay should run per outbox and consumers should expect duplicates.
+//
+// Positions are handed out when a row is inserted, not when its transaction
+// commits, so a later position can become visible before an earlier one. The
+// relay only publishes events once they are older than a visibility window,
+// stopping at the first that is not, on the basis that the transactions
+// writing them have committed by then. Transactions that stay open longer
+// than the window, or writers whose clocks are further apart than it, can
+// still see their events published out of order
+type Relay struct {
+	db         *sql.DB
+	outbox     Outbox
+	publisher  budevents.Publisher
+	interval   time.Duration
+	batchSize  int
+	visibility time.Duration
+	rejected   func(event budevents.Event, err error)
+}
+
+func NewRelay(db *sql.DB, outbox Outbox, publisher budevents.Publisher) Relay {
+	return Relay{
+		db:         db,
+		outbox:     outbox,
+		publisher:  publisher,
+		interval:   time.Second,
+		batchSize:  100,
+		visibility: 5 * time.Second,
+	}
+}
+
+func (relay Relay) WithInterval(interval time.Duration) Relay {
+	relay.interval = interval
+	return relay
+}
+
+func (relay Relay) WithBatchSize(batchSize int) Relay {
+	relay.batchSize = batchSize
+	return relay
+}
+
+// WithVisibilityWindow sets how old events must be before they are relayed,
+// which defaults to 5s. It should be longer than any transaction writing to
+// the outbox stays open
+func (relay Relay) WithVisibilityWindow(window time.Duration) Relay {
+	relay.visibility = window
+	return relay
+}
+
+// WithRejected is called with each event the sidecar rejects outright. Since
+// retrying them can never succeed, they are marked as failed in the outbox
+// and the events after them relayed
+func (relay Relay) WithRejected(rejected func(event budevents.Event, err error)) Relay {
+	relay.rejected = rejected
+	return relay
+}
+
+// Run relays pending events every interval until the context is done. Errors
+// are passed to onError and the events retried on the next interval
+func (relay Relay) Run(ctx context.Context, onError func(err error)) error {
+	ticker := time.NewTicker(relay.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		for {
+			relayed, err := relay.RelayPending(ctx)
+
+			if err != nil {
+				onError(err)
+				break
+			}
+
+			if relayed < relay.batchSize {
+				break
+			}
+		}
+	}
+}
+
+// RelayPending publishes up to a batch of pending events, stopping at the
+// first that is too recent or fails to reach the sidecar so that later events
+// are never published ahead of it. It returns how many events it relayed or
+// marked as failed
+func (relay Relay) RelayPending(ctx context.Context) (int, error) {
+	pending, err := relay.outbox.pending(ctx, relay.db, relay.batchSize)
+
+	if err != nil {
+		return 0, err
+	}
+
+	visible := time.Now().Add(-relay.visibility)
+
+	for i, row := range pending {
+		if row.createdAt.After(visible) {
+			return i, nil
+		}
+
+		if _, err := relay.publisher.Publish(ctx, row.event); err != nil {
+			if budevents.Retryable(err) {
+				return i, err
+			}
+
+			if err := relay.outbox.markFailed(ctx, relay.db, row.position, err); err != nil {
+				return i, err
+			}
+
+			if relay.rejected != nil {
+				relay.rejected(row.event, err)
+			}
+
+			continue
+		}
+
+		if err := relay.outbox.markSent(ctx, relay.db, row.position); err != nil {
+			return i, err
+		}
+	}
+
+	return len(pending), nil
+}
@@ -0,0 +1,108 @@
+package budevents
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"sync"
+)
+
+// Partitioning spreads the events of each poll across a pool of workers.
+// Events that share a key are always handled by the same worker, in the order
+// they occurred, so ordering is only guaranteed within a key
+type Partitioning struct {
+	Key     func(event Event) string
+	Workers int
+}
+
+// PayloadKey extracts a top-level string field from the payload of an event
+// (e.g. "loan_application_id") to use as a partition key
+func PayloadKey(field string) func(event Event) string {
+	return func(event Event) string {
+		var payload map[string]json.RawMessage
+
+		if err := json.Unmarshal(event.Payload, &payload); err != nil {
+			return ""
+		}
+
+		var key string
+
+		if err := json.Unmarshal(payload[field], &key); err != nil {
+			return string(payload[field])
+		}
+
+		return key
+	}
+}
+
+func (partitioning Partitioning) process(
+	ctx context.Context,
+	callback Callback,
+	events []Event,
+) (int, error) {
+	workers := partitioning.Workers
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	queues := make([][]int, workers)
+
+	for i, event := range events {
+		worker := partitioning.worker(event, workers)
+		queues[worker] = append(queues[worker], i)
+	}
+
+	processed := make([]bool, len(events))
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+
+	for worker, queue := range queues {
+		if len(queue) == 0 {
+			continue
+		}
+
+		wg.Add(1)
+		go func(worker int, queue []int) {
+			defer wg.Done()
+
+			for _, i := range queue {
+				if err := callback(ContextWithCause(ctx, events[i]), events[i]); err != nil {
+					errs[worker] = err
+					return
+				}
+
+				processed[i] = true
+			}
+		}(worker, queue)
+	}
+
+	wg.Wait()
+
+	// only the events before the first unprocessed one are safe to checkpoint;
+	// anything after it is delivered again when consuming resumes from there
+	contiguous := 0
+
+	for contiguous < len(processed) && processed[contiguous] {
+		contiguous++
+	}
+
+	for _, err := range errs {
+		if err != nil {
+			return contiguous, err
+		}
+	}
+
+	return contiguous, nil
+}
+
+func (partitioning Partitioning) worker(event Event, workers int) int {
+	if partitioning.Key == nil {
+		return 0
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(partitioning.Key(event)))
+
+	return int(h.Sum32() % uint32(workers))
+}
@@ -0,0 +1,81 @@
+package budevents
+
+import (
+	"math/rand"
+	"time"
+)
+
+const defaultTicker = 5 * time.Second
+
+// poller works out how long a listener waits between polls. With both
+// MinTicker and MaxTicker set, the interval halves toward the minimum while
+// polls keep finding new events and doubles toward the maximum while they come
+// back empty. Jitter spreads each interval by up to ±Jitter of its length, so
+// consumers started together don't keep polling a producer in lockstep
+type poller struct {
+	interval time.Duration
+	min      time.Duration
+	max      time.Duration
+	jitter   float64
+	rand     *rand.Rand
+}
+
+func newPoller(conf Listener) *poller {
+	p := &poller{
+		interval: time.Duration(conf.Ticker),
+		min:      time.Duration(conf.MinTicker),
+		max:      time.Duration(conf.MaxTicker),
+		jitter:   conf.Jitter,
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
+	if p.interval <= 0 {
+		p.interval = defaultTicker
+	}
+
+	if p.adaptive() {
+		p.interval = p.clamp(p.interval)
+	}
+
+	return p
+}
+
+func (p *poller) adaptive() bool {
+	return p.min > 0 && p.max >= p.min
+}
+
+// next returns the wait before the following poll, given whether the poll
+// that just finished found any new events
+func (p *poller) next(foundEvents bool) time.Duration {
+	if p.adaptive() {
+		if foundEvents {
+			p.interval = p.clamp(p.interval / 2)
+		} else {
+			p.interval = p.clamp(p.interval * 2)
+		}
+	}
+
+	return p.jittered(p.interval)
+}
+
+func (p *poller) jittered(interval time.Duration) time.Duration {
+	if p.jitter <= 0 {
+		return interval
+	}
+
+	spread := float64(interval) * p.jitter * (2*p.rand.Float64() - 1)
+
+	return interval + time.Duration(spread)
+}
+
+func (p *poller) clamp(interval time.Duration) time.Duration {
+	if interval < p.min {
+		return p.min
+	}
+
+	if interval > p.max {
+		return p.max
+	}
+
+	return interval
+}
@@ -0,0 +1,98 @@
+package budevents
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPollerAdaptsWithinItsBounds(t *testing.T) {
+	p := newPoller(Listener{
+		Ticker:    Duration(time.Second),
+		MinTicker: Duration(200 * time.Millisecond),
+		MaxTicker: Duration(4 * time.Second),
+	})
+
+	steps := []struct {
+		foundEvents bool
+		want        time.Duration
+	}{
+		{true, 500 * time.Millisecond},
+		{true, 250 * time.Millisecond},
+		{true, 200 * time.Millisecond},
+		{true, 200 * time.Millisecond},
+		{false, 400 * time.Millisecond},
+		{false, 800 * time.Millisecond},
+		{false, 1600 * time.Millisecond},
+		{false, 3200 * time.Millisecond},
+		{false, 4 * time.Second},
+		{false, 4 * time.Second},
+		{true, 2 * time.Second},
+	}
+
+	for i, step := range steps {
+		if got := p.next(step.foundEvents); got != step.want {
+			t.Fatalf("step [%d] waited [%s], want [%s]", i, got, step.want)
+		}
+	}
+}
+
+func TestPollerStartsWithinItsBounds(t *testing.T) {
+	tests := []struct {
+		name string
+		conf Listener
+		want time.Duration
+	}{
+		{"default ticker", Listener{}, defaultTicker},
+		{"fixed ticker", Listener{Ticker: Duration(time.Second)}, time.Second},
+		{"ticker below the minimum", Listener{Ticker: Duration(time.Millisecond), MinTicker: Duration(time.Second), MaxTicker: Duration(time.Minute)}, time.Second},
+		{"ticker above the maximum", Listener{Ticker: Duration(time.Hour), MinTicker: Duration(time.Second), MaxTicker: Duration(time.Minute)}, time.Minute},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := newPoller(test.conf).interval; got != test.want {
+				t.Errorf("interval is [%s], want [%s]", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPollerWithoutBoundsKeepsItsTicker(t *testing.T) {
+	p := newPoller(Listener{Ticker: Duration(time.Second)})
+
+	for _, foundEvents := range []bool{true, true, false, false} {
+		if got := p.next(foundEvents); got != time.Second {
+			t.Fatalf("waited [%s], want the fixed [%s]", got, time.Second)
+		}
+	}
+}
+
+func TestPollerJitterStaysWithinItsSpread(t *testing.T) {
+	p := newPoller(Listener{
+		Ticker:    Duration(time.Second),
+		MinTicker: Duration(time.Second),
+		MaxTicker: Duration(time.Second),
+		Jitter:    0.2,
+	})
+
+	varied := false
+
+	for i := 0; i < 1000; i++ {
+		got := p.next(i%2 == 0)
+
+		if got < 800*time.Millisecond || got > 1200*time.Millisecond {
+			t.Fatalf("waited [%s], want within 20%% of [%s]", got, time.Second)
+		}
+
+		varied = varied || got != time.Second
+	}
+
+	if !varied {
+		t.Error("jitter never changed the interval")
+	}
+
+	// jitter spreads each wait without drifting the interval it adapts from
+	if p.interval != time.Second {
+		t.Errorf("interval drifted to [%s]", p.interval)
+	}
+}
@@ -0,0 +1,34 @@
+package budevents
+
+const ProblemContentType = "application/problem+json"
+
+// Problem codes identify the kind of problem an error response describes
+const (
+	ProblemInvalidBody        = "invalid_body"
+	ProblemInvalidQuery       = "invalid_query"
+	ProblemInvalidHeader      = "invalid_header"
+	ProblemBodyTooLarge       = "body_too_large"
+	ProblemInvalidEvent       = "invalid_event"
+	ProblemEventNotFound      = "event_not_found"
+	ProblemEventConflict      = "event_conflict"
+	ProblemPreconditionFailed = "precondition_failed"
+	ProblemSchemaNotFound     = "schema_not_found"
+	ProblemInvalidSchema      = "invalid_schema"
+	ProblemIncompatibleSchema = "incompatible_schema"
+	ProblemInternalError      = "internal_error"
+)
+
+// Problem is an RFC 7807 error response, extended with a machine-readable
+// code and the violations that made a request invalid
+type Problem struct {
+	Title      string      `json:"title"`
+	Status     int         `json:"status"`
+	Detail     string      `json:"detail,omitempty"`
+	Code       string      `json:"code"`
+	Violations []Violation `json:"violations,omitempty"`
+}
+
+type Violation struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
@@ -0,0 +1,340 @@
+package budevents
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Publisher publishes events to the sidecar over HTTP. Events are given an ID
+// and occurrence time before the first attempt, so that retries re-send an
+// identical event rather than publishing a new one
+type Publisher struct {
+	baseURL  string
+	client   *http.Client
+	retries  int
+	backoff  time.Duration
+	spool    *Spool
+	rejected func(events []Event, err error)
+	producer string
+}
+
+func NewPublisher(baseURL string) Publisher {
+	return Publisher{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  http.DefaultClient,
+		retries: 3,
+		backoff: 100 * time.Millisecond,
+	}
+}
+
+func (publisher Publisher) WithHTTPClient(client *http.Client) Publisher {
+	publisher.client = client
+	return publisher
+}
+
+// WithRetries sets how many times a failed publish is retried, doubling the
+// backoff between each attempt. Only network errors, 429 and 5xx responses
+// are retried
+func (publisher Publisher) WithRetries(retries int, backoff time.Duration) Publisher {
+	publisher.retries = retries
+	publisher.backoff = backoff
+	return publisher
+}
+
+// WithProducer names the service publishing events, for events that do not
+// name one themselves
+func (publisher Publisher) WithProducer(producer string) Publisher {
+	publisher.producer = producer
+	return publisher
+}
+
+// NewEvent marshals a typed payload into an event
+func NewEvent(eventName string, payload interface{}) (Event, error) {
+	blob, err := json.Marshal(payload)
+
+	if err != nil {
+		return Event{}, err
+	}
+
+	return Event{
+		EventName: eventName,
+		Payload:   blob,
+	}, nil
+}
+
+// Publish returns the published event along with its self link, parsed from
+// the Location header of the response. With a spool, an event that could not
+// reach the sidecar is spooled instead and returned without a self link
+func (publisher Publisher) Publish(ctx context.Context, event Event) (*Response, error) {
+	event = publisher.prepare(ctx, event)
+
+	if publisher.spool == nil {
+		return publisher.send(ctx, event, http.Header{})
+	}
+
+	published, err := publisher.publishSpooled(ctx, []Event{event}, func(ctx context.Context) ([]Response, error) {
+		resp, err := publisher.send(ctx, event, http.Header{})
+
+		if err != nil {
+			return nil, err
+		}
+
+		return []Response{*resp}, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &published[0], nil
+}
+
+// PublishIfLatest only publishes the event if expectedPreviousEventID is still
+// the latest event in the stream, failing with a 412 PublishError otherwise.
+// Since the check only makes sense against the stream as it is now, these
+// events are never spooled
+func (publisher Publisher) PublishIfLatest(
+	ctx context.Context,
+	expectedPreviousEventID string,
+	event Event,
+) (*Response, error) {
+	return publisher.send(ctx, publisher.prepare(ctx, event), http.Header{
+		"If-Match": []string{`"` + expectedPreviousEventID + `"`},
+	})
+}
+
+func (publisher Publisher) send(ctx context.Context, event Event, header http.Header) (*Response, error) {
+	blob, err := json.Marshal(event)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var respHeader http.Header
+	var body []byte
+
+	err = publisher.retry(ctx, func() error {
+		respHeader, body, err = publisher.post(ctx, "/v1/events", blob, header)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	self := Reference{
+		Href: respHeader.Get("Location"),
+		Type: http.MethodGet,
+	}
+
+	// the sidecar responds with the self link, including the sequence it
+	// assigned, though older versions only set the Location header
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := json.Unmarshal(body, &self); err != nil {
+			return nil, err
+		}
+	}
+
+	event.Sequence = self.Sequence
+
+	return &Response{
+		Schema: event.Schema,
+		Data:   event,
+		Metadata: map[string]Reference{
+			"self": self,
+		},
+	}, nil
+}
+
+// PublishBatch publishes the events atomically, in order: either all of them
+// are published or none are
+func (publisher Publisher) PublishBatch(ctx context.Context, events ...Event) ([]Response, error) {
+	prepared := make([]Event, len(events))
+
+	for i, event := range events {
+		prepared[i] = publisher.prepare(ctx, event)
+	}
+
+	if publisher.spool == nil {
+		return publisher.sendBatch(ctx, prepared)
+	}
+
+	return publisher.publishSpooled(ctx, prepared, func(ctx context.Context) ([]Response, error) {
+		return publisher.sendBatch(ctx, prepared)
+	})
+}
+
+func (publisher Publisher) sendBatch(ctx context.Context, events []Event) ([]Response, error) {
+	blob, err := json.Marshal(BatchRequest{Events: events})
+
+	if err != nil {
+		return nil, err
+	}
+
+	var body []byte
+
+	err = publisher.retry(ctx, func() error {
+		_, body, err = publisher.post(ctx, "/v1/events:batch", blob, http.Header{})
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	var batch BatchResponse
+
+	if err := json.Unmarshal(body, &batch); err != nil {
+		return nil, err
+	}
+
+	if len(batch.Events) != len(events) {
+		return nil, fmt.Errorf("expected [%d] published events, got [%d]", len(events), len(batch.Events))
+	}
+
+	published := make([]Response, len(events))
+
+	for i, event := range events {
+		event.Sequence = batch.Events[i].Sequence
+		published[i] = Response{
+			Schema: event.Schema,
+			Data:   event,
+			Metadata: map[string]Reference{
+				"self": batch.Events[i],
+			},
+		}
+	}
+
+	return published, nil
+}
+
+// prepare fills in the envelope of an event, correlating it with the event
+// being handled in ctx if there is one
+func (publisher Publisher) prepare(ctx context.Context, event Event) Event {
+	if event.EventID == "" {
+		event.EventID = uuid.NewString()
+	}
+
+	if event.OccurredAt.IsZero() {
+		event.OccurredAt = time.Now().UTC()
+	}
+
+	if event.Producer == "" {
+		event.Producer = publisher.producer
+	}
+
+	return Correlate(ctx, event)
+}
+
+func (publisher Publisher) retry(ctx context.Context, attempt func() error) error {
+	backoff := publisher.backoff
+
+	for i := 0; ; i++ {
+		err := attempt()
+
+		if err == nil {
+			return nil
+		}
+
+		if !Retryable(err) || i >= publisher.retries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+	}
+}
+
+func (publisher Publisher) post(
+	ctx context.Context,
+	path string,
+	blob []byte,
+	header http.Header,
+) (http.Header, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, publisher.baseURL+path, bytes.NewReader(blob))
+
+	if err != nil {
+		return nil, nil, &PublishError{Err: err}
+	}
+
+	req.Header = header.Clone()
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := publisher.client.Do(req)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		perr := &PublishError{StatusCode: resp.StatusCode}
+
+		if strings.HasPrefix(resp.Header.Get("Content-Type"), ProblemContentType) {
+			var problem Problem
+
+			if err := json.NewDecoder(resp.Body).Decode(&problem); err == nil {
+				perr.Problem = &problem
+			}
+		}
+
+		return nil, nil, perr
+	}
+
+	body, err := io.ReadAll(resp.Body)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return resp.Header, body, nil
+}
+
+// PublishError is returned when the sidecar rejects an event, along with the
+// problem it responded with, or when the request to publish it could not be
+// built
+type PublishError struct {
+	StatusCode int
+	Problem    *Problem
+	Err        error
+}
+
+func (err *PublishError) Error() string {
+	if err.Err != nil {
+		return err.Err.Error()
+	}
+
+	if err.Problem != nil {
+		return fmt.Sprintf("bad status code [%d]: %s: %s", err.StatusCode, err.Problem.Code, err.Problem.Detail)
+	}
+
+	return fmt.Sprintf("bad status code [%d]", err.StatusCode)
+}
+
+func (err *PublishError) Unwrap() error {
+	return err.Err
+}
+
+// Retryable reports whether publishing failed because the sidecar could not be
+// reached or was unable to handle the request, rather than rejecting the event
+func Retryable(err error) bool {
+	perr, ok := err.(*PublishError)
+
+	if !ok {
+		return true
+	}
+
+	return perr.StatusCode == http.StatusTooManyRequests || perr.StatusCode >= http.StatusInternalServerError
+}
@@ -0,0 +1,69 @@
+package budevents
+
+import (
+	"context"
+	"sync"
+)
+
+type Handler func(ctx context.Context, event Event) error
+
+// Router dispatches each event to the handler registered for its schema or,
+// failing that, its event name. Events without a handler are ignored. Its
+// Handle method can be used as the callback of a consumer
+type Router struct {
+	mu       *sync.RWMutex
+	byName   map[string]Handler
+	bySchema map[string]Handler
+}
+
+func NewRouter() *Router {
+	return &Router{
+		mu:       new(sync.RWMutex),
+		byName:   map[string]Handler{},
+		bySchema: map[string]Handler{},
+	}
+}
+
+func (router *Router) On(eventName string, handler Handler) *Router {
+	router.mu.Lock()
+	defer router.mu.Unlock()
+
+	router.byName[eventName] = handler
+	return router
+}
+
+func (router *Router) OnSchema(schema string, handler Handler) *Router {
+	router.mu.Lock()
+	defer router.mu.Unlock()
+
+	router.bySchema[schema] = handler
+	return router
+}
+
+func (router *Router) Handle(ctx context.Context, events ...Event) error {
+	for _, event := range events {
+		handler, ok := router.route(event)
+
+		if !ok {
+			continue
+		}
+
+		if err := handler(ContextWithCause(ctx, event), event); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (router *Router) route(event Event) (Handler, bool) {
+	router.mu.RLock()
+	defer router.mu.RUnlock()
+
+	if handler, ok := router.bySchema[event.Schema]; ok && event.Schema != "" {
+		return handler, true
+	}
+
+	handler, ok := router.byName[event.EventName]
+	return handler, ok
+}
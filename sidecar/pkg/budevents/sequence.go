@@ -0,0 +1,41 @@
+package budevents
+
+import "fmt"
+
+// SequenceGapError is reported to the OnError hooks of a listener when an event
+// is missing from the stream, found by the link to the previous event skipping
+// a sequence number. Consuming carries on from the events that are there
+type SequenceGapError struct {
+	EventID string
+	// Expected is the sequence the event before EventID should have had, and
+	// Actual the sequence it had
+	Expected int64
+	Actual   int64
+}
+
+func (err *SequenceGapError) Error() string {
+	return fmt.Sprintf("sequence gap before event [%s]: expected [%d], got [%d]", err.EventID, err.Expected, err.Actual)
+}
+
+// sequenceGap compares the sequence of an event with the sequence of the event
+// its next link points to. Sequences are those of the stream being read, so
+// taken from the links, and events without them are not checked
+func sequenceGap(resp *Response) *SequenceGapError {
+	sequence := resp.Metadata["self"].Sequence
+
+	if sequence == 0 {
+		sequence = resp.Data.Sequence
+	}
+
+	previous := resp.Metadata["next"].Sequence
+
+	if sequence == 0 || previous == 0 || previous == sequence-1 {
+		return nil
+	}
+
+	return &SequenceGapError{
+		EventID:  resp.Data.EventID,
+		Expected: sequence - 1,
+		Actual:   previous,
+	}
+}
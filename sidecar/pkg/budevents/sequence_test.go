@@ -0,0 +1,102 @@
+package budevents_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/thisisbud/backend-events-sidecar/pkg/budevents"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// gapRecorder records the sequence gaps a consumer reports
+type gapRecorder struct {
+	budevents.NopHooks
+	mu   *sync.Mutex
+	gaps []*budevents.SequenceGapError
+}
+
+func (rec *gapRecorder) OnError(ctx context.Context, conf budevents.Listener, err error) {
+	var gap *budevents.SequenceGapError
+
+	if errors.As(err, &gap) {
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+
+		rec.gaps = append(rec.gaps, gap)
+	}
+}
+
+func (rec *gapRecorder) count() int {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	return len(rec.gaps)
+}
+
+func TestConsumerReportsSequenceGapsOldestFirst(t *testing.T) {
+	// a stream of 15 events numbered 1, 3, 5 and so on, missing every other
+	const count = 15
+
+	reference := func(n int) budevents.Reference {
+		return budevents.Reference{
+			Href:     fmt.Sprintf("/v1/events/%d", n),
+			Type:     http.MethodGet,
+			Sequence: int64(2*n + 1),
+		}
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := count - 1
+
+		if r.URL.Path != "/v1/events" {
+			n, _ = strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/v1/events/"))
+		}
+
+		resp := budevents.Response{
+			Data: budevents.Event{
+				EventID:   strconv.Itoa(n),
+				EventName: "numbered",
+				Payload:   json.RawMessage(`{}`),
+				Sequence:  int64(2*n + 1),
+			},
+			Metadata: map[string]budevents.Reference{"self": reference(n)},
+		}
+
+		if n > 0 {
+			resp.Metadata["next"] = reference(n - 1)
+		}
+
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+
+	rec := &gapRecorder{mu: new(sync.Mutex)}
+	conf := listener(server.URL)
+	conf.WellKnownPath = "/v1/events"
+
+	consumer, err := budevents.NewConsumer(func(ctx context.Context, events ...budevents.Event) error {
+		return nil
+	}, conf)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = consumeUntil(t, consumer.WithHooks(rec), func() bool { return rec.count() >= count-1 })
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, gap := range rec.gaps[:count-1] {
+		if want := strconv.Itoa(i + 1); gap.EventID != want {
+			t.Fatalf("gap [%d] is before event [%s], want [%s]", i, gap.EventID, want)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package budevents_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/thisisbud/backend-events-sidecar/pkg/budevents"
+	"sync"
+	"testing"
+	"time"
+)
+
+// publishNumbered publishes count events whose payloads hold their number n
+// and a partition key k cycling through keys values
+func publishNumbered(t *testing.T, baseURL string, count int, keys int) []budevents.Event {
+	t.Helper()
+
+	events := make([]budevents.Event, count)
+
+	for i := range events {
+		events[i] = budevents.Event{
+			EventName: "numbered",
+			Payload:   json.RawMessage(fmt.Sprintf(`{"n":%d,"k":"%d"}`, i, i%keys)),
+		}
+	}
+
+	responses, err := budevents.NewPublisher(baseURL).PublishBatch(context.Background(), events...)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, resp := range responses {
+		events[i] = resp.Data
+	}
+
+	return events
+}
+
+func number(t *testing.T, event budevents.Event) int {
+	t.Helper()
+
+	var payload struct {
+		N int `json:"n"`
+	}
+
+	if err := json.Unmarshal(event.Payload, &payload); err != nil {
+		t.Fatal(err)
+	}
+
+	return payload.N
+}
+
+// recorder records what a consumer delivers and checkpoints
+type recorder struct {
+	budevents.NopHooks
+	mu          *sync.Mutex
+	delivered   []budevents.Event
+	checkpoints []string
+}
+
+func newRecorder() *recorder {
+	return &recorder{mu: new(sync.Mutex)}
+}
+
+func (rec *recorder) record(events ...budevents.Event) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	rec.delivered = append(rec.delivered, events...)
+}
+
+func (rec *recorder) OnCheckpoint(ctx context.Context, conf budevents.Listener, eventID string) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	rec.checkpoints = append(rec.checkpoints, eventID)
+}
+
+func (rec *recorder) snapshot() ([]budevents.Event, []string) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	return append([]budevents.Event(nil), rec.delivered...), append([]string(nil), rec.checkpoints...)
+}
+
+// consumeUntil runs a consumer until done reports true or the test times out,
+// returning the error it stopped with
+func consumeUntil(t *testing.T, consumer budevents.Consumer, done func() bool) error {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	errs := make(chan error, 1)
+	go func() { errs <- consumer.Consume(ctx) }()
+
+	for {
+		select {
+		case err := <-errs:
+			return err
+		case <-ctx.Done():
+			t.Fatal("timed out consuming")
+		case <-time.After(10 * time.Millisecond):
+			if done() {
+				cancel()
+				<-errs
+				return nil
+			}
+		}
+	}
+}
+
+func listener(baseURL string) budevents.Listener {
+	return budevents.Listener{
+		BaseURL: baseURL,
+		Ticker:  budevents.Duration(10 * time.Millisecond),
+	}
+}
@@ -0,0 +1,260 @@
+package budevents
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+var ErrSpoolFull = errors.New("spool is full")
+
+// Spool is an append-only file of events that could not be published while
+// the sidecar was unreachable, one line per publish so that batches stay
+// atomic. Events are drained from it in the order they were spooled, and the
+// file is truncated once it has been fully drained.
+// Progress through the file is only kept in memory, so events drained before
+// a restart are published again - which the sidecar treats as a no-op, since
+// each spooled event keeps its ID
+type Spool struct {
+	mu       *sync.Mutex
+	file     *os.File
+	maxBytes int64
+	offset   int64
+	size     int64
+	events   int
+}
+
+type SpoolStats struct {
+	Events int   `json:"events"`
+	Bytes  int64 `json:"bytes"`
+}
+
+// OpenSpool opens (or creates) a spool file, which is allowed to grow up to
+// maxBytes. A zero maxBytes leaves it unbounded
+func OpenSpool(filename string, maxBytes int64) (*Spool, error) {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+
+	if err != nil {
+		return nil, err
+	}
+
+	spool := &Spool{
+		mu:       new(sync.Mutex),
+		file:     file,
+		maxBytes: maxBytes,
+	}
+
+	reader := bufio.NewReader(file)
+
+	for {
+		line, err := reader.ReadBytes('\n')
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+
+		var events []Event
+
+		if err := json.Unmarshal(line, &events); err != nil {
+			file.Close()
+			return nil, err
+		}
+
+		spool.size += int64(len(line))
+		spool.events += len(events)
+	}
+
+	return spool, nil
+}
+
+// WithSpool spools events that fail to reach the sidecar, and publishes
+// spooled events before any new ones so that their order is preserved.
+// Spooled events the sidecar rejects outright are dropped, since retrying them
+// can never succeed, and passed to rejected along with why
+func (publisher Publisher) WithSpool(spool *Spool, rejected func(events []Event, err error)) Publisher {
+	publisher.spool = spool
+	publisher.rejected = rejected
+	return publisher
+}
+
+func (spool *Spool) Stats() SpoolStats {
+	spool.mu.Lock()
+	defer spool.mu.Unlock()
+
+	return SpoolStats{
+		Events: spool.events,
+		Bytes:  spool.size - spool.offset,
+	}
+}
+
+func (spool *Spool) Close() error {
+	return spool.file.Close()
+}
+
+func (spool *Spool) append(events []Event) error {
+	line, err := json.Marshal(events)
+
+	if err != nil {
+		return err
+	}
+
+	line = append(line, '\n')
+
+	if spool.maxBytes > 0 && spool.size-spool.offset+int64(len(line)) > spool.maxBytes {
+		return ErrSpoolFull
+	}
+
+	if _, err := spool.file.Write(line); err != nil {
+		return err
+	}
+
+	if err := spool.file.Sync(); err != nil {
+		return err
+	}
+
+	spool.size += int64(len(line))
+	spool.events += len(events)
+
+	return nil
+}
+
+// drain publishes spooled events in order until the spool is empty or an
+// event fails to reach the sidecar. Events the sidecar rejects outright are
+// dropped and passed to rejected
+func (spool *Spool) drain(
+	ctx context.Context,
+	publish func(ctx context.Context, events []Event) error,
+	rejected func(events []Event, err error),
+) error {
+	for spool.offset < spool.size {
+		line, err := spool.read()
+
+		if err != nil {
+			return err
+		}
+
+		var events []Event
+
+		if err := json.Unmarshal(line, &events); err != nil {
+			return err
+		}
+
+		if err := publish(ctx, events); err != nil {
+			if Retryable(err) {
+				return err
+			}
+
+			if rejected != nil {
+				rejected(events, err)
+			}
+		}
+
+		spool.offset += int64(len(line))
+		spool.events -= len(events)
+	}
+
+	if err := spool.file.Truncate(0); err != nil {
+		return err
+	}
+
+	spool.offset, spool.size = 0, 0
+
+	return nil
+}
+
+func (spool *Spool) read() ([]byte, error) {
+	reader := bufio.NewReader(io.NewSectionReader(spool.file, spool.offset, spool.size-spool.offset))
+
+	return reader.ReadBytes('\n')
+}
+
+func (publisher Publisher) publishSpooled(
+	ctx context.Context,
+	events []Event,
+	send func(ctx context.Context) ([]Response, error),
+) ([]Response, error) {
+	publisher.spool.mu.Lock()
+	defer publisher.spool.mu.Unlock()
+
+	// a rejected spooled event is dropped rather than holding up the rest, so
+	// only failing to reach the sidecar keeps new events behind the spool
+	err := publisher.spool.drain(ctx, publisher.sendOnce, publisher.rejected)
+
+	if err != nil && !Retryable(err) {
+		return nil, err
+	}
+
+	if err == nil {
+		published, err := send(ctx)
+
+		if err == nil || !Retryable(err) {
+			return published, err
+		}
+	}
+
+	if err := publisher.spool.append(events); err != nil {
+		return nil, err
+	}
+
+	spooled := make([]Response, len(events))
+
+	for i, event := range events {
+		spooled[i] = Response{Data: event}
+	}
+
+	return spooled, nil
+}
+
+// sendOnce publishes without retrying, so that publishing fails fast while
+// the sidecar is unreachable and events are spooled instead
+func (publisher Publisher) sendOnce(ctx context.Context, events []Event) error {
+	publisher = publisher.WithRetries(0, 0)
+
+	if len(events) == 1 {
+		_, err := publisher.send(ctx, events[0], http.Header{})
+		return err
+	}
+
+	_, err := publisher.sendBatch(ctx, events)
+	return err
+}
+
+// DrainSpool publishes any spooled events, failing if the sidecar cannot be
+// reached. Rejected events are passed to the callback given to WithSpool
+func (publisher Publisher) DrainSpool(ctx context.Context) error {
+	publisher.spool.mu.Lock()
+	defer publisher.spool.mu.Unlock()
+
+	return publisher.spool.drain(ctx, publisher.sendOnce, publisher.rejected)
+}
+
+// RunSpool drains the spool every interval until the context is done, so that
+// spooled events are published once the sidecar is reachable again even if
+// nothing new is published. Errors are passed to onError
+func (publisher Publisher) RunSpool(ctx context.Context, interval time.Duration, onError func(err error)) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		if err := publisher.DrainSpool(ctx); err != nil {
+			onError(err)
+		}
+	}
+}
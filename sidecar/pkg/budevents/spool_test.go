@@ -0,0 +1,205 @@
+package budevents_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/thisisbud/backend-events-sidecar/internal/sidecartest"
+	"github.com/thisisbud/backend-events-sidecar/pkg/budevents"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// outage puts a sidecar behind a switch, answering 503 to publishes while it
+// is down. While up, it lets through a limited number of publishes when
+// allowed is not negative, before going down again
+type outage struct {
+	mu      *sync.Mutex
+	down    bool
+	allowed int
+}
+
+func newOutage(server *httptest.Server) *outage {
+	o := &outage{mu: new(sync.Mutex), allowed: -1}
+	next := server.Config.Handler
+
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && !o.admit() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+
+	return o
+}
+
+func (o *outage) admit() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.down {
+		return false
+	}
+
+	if o.allowed == 0 {
+		o.down = true
+		return false
+	}
+
+	if o.allowed > 0 {
+		o.allowed--
+	}
+
+	return true
+}
+
+func (o *outage) set(down bool, allowed int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.down, o.allowed = down, allowed
+}
+
+func openSpool(t *testing.T, maxBytes int64) *budevents.Spool {
+	t.Helper()
+
+	spool, err := budevents.OpenSpool(filepath.Join(t.TempDir(), "spool"), maxBytes)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { spool.Close() })
+	return spool
+}
+
+func publishNamed(t *testing.T, publisher budevents.Publisher, eventName string) *budevents.Response {
+	t.Helper()
+
+	resp, err := publisher.Publish(context.Background(), budevents.Event{
+		EventName: eventName,
+		Payload:   json.RawMessage(`{}`),
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp
+}
+
+func TestSpoolDrainsInOrderOnceTheSidecarIsBack(t *testing.T) {
+	sidecar := sidecartest.New(t)
+	o := newOutage(sidecar)
+	spool := openSpool(t, 0)
+	publisher := budevents.NewPublisher(sidecar.URL).WithRetries(0, 0).WithSpool(spool, nil)
+
+	o.set(true, -1)
+	publishNamed(t, publisher, "first")
+	publishNamed(t, publisher, "second")
+
+	if stats := spool.Stats(); stats.Events != 2 {
+		t.Fatalf("spooled [%d] events, want [2]", stats.Events)
+	}
+
+	o.set(false, -1)
+	resp := publishNamed(t, publisher, "third")
+
+	if resp.Metadata["self"].Href == "" {
+		t.Error("the event was spooled once the sidecar was back, want it published")
+	}
+
+	if stats := spool.Stats(); stats.Events != 0 || stats.Bytes != 0 {
+		t.Errorf("spool holds %+v after draining, want it empty", stats)
+	}
+
+	if names := sidecartest.StreamNames(t, sidecar.URL); !reflect.DeepEqual(names, []string{"first", "second", "third"}) {
+		t.Errorf("stream is %v, want the spooled events first", names)
+	}
+}
+
+func TestSpoolReportsRejectedEvents(t *testing.T) {
+	sidecar := sidecartest.New(t)
+	o := newOutage(sidecar)
+	spool := openSpool(t, 0)
+
+	var rejected []budevents.Event
+	var rejections []error
+
+	publisher := budevents.NewPublisher(sidecar.URL).
+		WithRetries(0, 0).
+		WithSpool(spool, func(events []budevents.Event, err error) {
+			rejected = append(rejected, events...)
+			rejections = append(rejections, err)
+		})
+
+	o.set(true, -1)
+	publishNamed(t, publisher, "first")
+	// event names must be snake_case, so the sidecar rejects this one
+	publishNamed(t, publisher, "Not Snake Case")
+
+	o.set(false, -1)
+
+	if err := publisher.DrainSpool(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rejected) != 1 || rejected[0].EventName != "Not Snake Case" {
+		t.Fatalf("rejected %v, want the badly named event", rejected)
+	}
+
+	var perr *budevents.PublishError
+
+	if !errors.As(rejections[0], &perr) || perr.StatusCode != http.StatusBadRequest {
+		t.Errorf("rejected with [%v], want a 400", rejections[0])
+	}
+
+	if names := sidecartest.StreamNames(t, sidecar.URL); !reflect.DeepEqual(names, []string{"first"}) {
+		t.Errorf("stream is %v, want only the accepted event", names)
+	}
+}
+
+func TestSpoolLimitCountsOnlyUndrainedEvents(t *testing.T) {
+	sidecar := sidecartest.New(t)
+	o := newOutage(sidecar)
+
+	// measure how big a spooled event is
+	measure := openSpool(t, 0)
+	o.set(true, -1)
+	publishNamed(t, budevents.NewPublisher(sidecar.URL).WithRetries(0, 0).WithSpool(measure, nil), "first")
+	size := measure.Stats().Bytes
+
+	// room for two and a half events
+	spool := openSpool(t, size*5/2)
+	publisher := budevents.NewPublisher(sidecar.URL).WithRetries(0, 0).WithSpool(spool, nil)
+
+	publishNamed(t, publisher, "first")
+	publishNamed(t, publisher, "second")
+
+	// the sidecar comes back for long enough to drain the first event only
+	o.set(false, 1)
+
+	if _, err := publisher.Publish(context.Background(), budevents.Event{
+		EventName: "third",
+		Payload:   json.RawMessage(`{}`),
+	}); err != nil {
+		t.Fatalf("spooling after a partial drain: %v", err)
+	}
+
+	if stats := spool.Stats(); stats.Events != 2 {
+		t.Errorf("spooled [%d] events, want [2]", stats.Events)
+	}
+
+	if _, err := publisher.Publish(context.Background(), budevents.Event{
+		EventName: "fourth",
+		Payload:   json.RawMessage(`{}`),
+	}); !errors.Is(err, budevents.ErrSpoolFull) {
+		t.Errorf("spooling past the limit failed with [%v], want [%v]", err, budevents.ErrSpoolFull)
+	}
+}
@@ -0,0 +1,143 @@
+package budevents
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Upcaster transforms an event's payload from one version of its schema to the
+// next
+type Upcaster func(payload json.RawMessage) (json.RawMessage, error)
+
+type upcasterKey struct {
+	eventName string
+	version   int
+}
+
+// WithUpcaster registers an upcaster from fromVersion of an event's schema to
+// the version after it. Before middleware or the callback see an event, its
+// payload is passed through the upcasters from its version onwards, so that
+// callbacks only handle the latest shape. Events without a schema version are
+// treated as version 1
+func (consumer Consumer) WithUpcaster(eventName string, fromVersion int, upcaster Upcaster) Consumer {
+	upcasters := make(map[upcasterKey]Upcaster, len(consumer.upcasters)+1)
+
+	for key, existing := range consumer.upcasters {
+		upcasters[key] = existing
+	}
+
+	upcasters[upcasterKey{eventName: eventName, version: fromVersion}] = upcaster
+	consumer.upcasters = upcasters
+	return consumer
+}
+
+func upcast(upcasters map[upcasterKey]Upcaster, next Callback) Callback {
+	return func(ctx context.Context, events ...Event) error {
+		upcast := make([]Event, len(events))
+
+		for i, event := range events {
+			var err error
+			upcast[i], err = upcastEvent(upcasters, event)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return next(ctx, upcast...)
+	}
+}
+
+func upcastEvent(upcasters map[upcasterKey]Upcaster, event Event) (Event, error) {
+	version := schemaVersion(event)
+	from := version
+
+	for {
+		upcaster, ok := upcasters[upcasterKey{eventName: event.EventName, version: version}]
+
+		if !ok {
+			break
+		}
+
+		payload, err := upcaster(event.Payload)
+
+		if err != nil {
+			return event, fmt.Errorf("upcasting event [%s] from version [%d]: %w", event.EventID, version, err)
+		}
+
+		event.Payload = payload
+		version++
+	}
+
+	if version != from {
+		event.Schema = withSchemaVersion(event.Schema, version)
+		event.SchemaVersion = version
+	}
+
+	return event, nil
+}
+
+// schemaVersion is the event's schema version, otherwise the version at the
+// end of its schema URL, such as /v1/schemas/order_placed/2, defaulting to 1
+func schemaVersion(event Event) int {
+	if event.SchemaVersion > 0 {
+		return event.SchemaVersion
+	}
+
+	if _, version, ok := splitSchemaVersion(event.Schema); ok {
+		return version
+	}
+
+	return 1
+}
+
+// withSchemaVersion replaces the version at the end of a schema URL, keeping
+// URLs without one as they are
+func withSchemaVersion(schemaURL string, version int) string {
+	u, err := url.Parse(schemaURL)
+
+	if err != nil {
+		return schemaURL
+	}
+
+	format, _, ok := splitSchemaVersion(schemaURL)
+
+	if !ok {
+		return schemaURL
+	}
+
+	u.Path = path.Join(path.Dir(u.Path), fmt.Sprintf(format, version))
+	return u.String()
+}
+
+// splitSchemaVersion finds the version in the last segment of a schema URL,
+// allowing for forms like 2, v2 and 2.json, and returns a format to write
+// another version in the same form
+func splitSchemaVersion(schemaURL string) (string, int, bool) {
+	u, err := url.Parse(schemaURL)
+
+	if err != nil || u.Path == "" {
+		return "", 0, false
+	}
+
+	segment := path.Base(u.Path)
+	prefix, suffix := "", path.Ext(segment)
+	segment = strings.TrimSuffix(segment, suffix)
+
+	if strings.HasPrefix(segment, "v") {
+		prefix, segment = "v", segment[1:]
+	}
+
+	version, err := strconv.Atoi(segment)
+
+	if err != nil || version < 1 {
+		return "", 0, false
+	}
+
+	return prefix + "%d" + suffix, version, true
+}
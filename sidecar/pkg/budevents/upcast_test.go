@@ -0,0 +1,122 @@
+package budevents
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// renameField upcasts by renaming a payload field
+func renameField(from string, to string) Upcaster {
+	return func(payload json.RawMessage) (json.RawMessage, error) {
+		return json.RawMessage(strings.Replace(string(payload), `"`+from+`"`, `"`+to+`"`, 1)), nil
+	}
+}
+
+func TestUpcastEvent(t *testing.T) {
+	upcasters := Consumer{}.
+		WithUpcaster("order_placed", 1, renameField("amount", "total")).
+		WithUpcaster("order_placed", 2, renameField("total", "total_pence")).
+		upcasters
+
+	tests := []struct {
+		name          string
+		event         Event
+		payload       string
+		schema        string
+		schemaVersion int
+	}{
+		{
+			"from the first version",
+			Event{EventName: "order_placed", Payload: json.RawMessage(`{"amount":1}`), Schema: "/v1/schemas/order_placed/1", SchemaVersion: 1},
+			`{"total_pence":1}`, "/v1/schemas/order_placed/3", 3,
+		},
+		{
+			"from a middle version",
+			Event{EventName: "order_placed", Payload: json.RawMessage(`{"total":1}`), Schema: "/v1/schemas/order_placed/2", SchemaVersion: 2},
+			`{"total_pence":1}`, "/v1/schemas/order_placed/3", 3,
+		},
+		{
+			"already the latest version",
+			Event{EventName: "order_placed", Payload: json.RawMessage(`{"total_pence":1}`), Schema: "/v1/schemas/order_placed/3", SchemaVersion: 3},
+			`{"total_pence":1}`, "/v1/schemas/order_placed/3", 3,
+		},
+		{
+			"version from the schema URL",
+			Event{EventName: "order_placed", Payload: json.RawMessage(`{"total":1}`), Schema: "https://schemas.example.com/order_placed/v2.json"},
+			`{"total_pence":1}`, "https://schemas.example.com/order_placed/v3.json", 3,
+		},
+		{
+			"without a version",
+			Event{EventName: "order_placed", Payload: json.RawMessage(`{"amount":1}`)},
+			`{"total_pence":1}`, "", 3,
+		},
+		{
+			"schema URL without a version",
+			Event{EventName: "order_placed", Payload: json.RawMessage(`{"amount":1}`), Schema: "/schemas/order_placed"},
+			`{"total_pence":1}`, "/schemas/order_placed", 3,
+		},
+		{
+			"another event",
+			Event{EventName: "order_shipped", Payload: json.RawMessage(`{"amount":1}`), SchemaVersion: 1},
+			`{"amount":1}`, "", 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			event, err := upcastEvent(upcasters, test.event)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(event.Payload) != test.payload {
+				t.Errorf("payload is %s, want %s", event.Payload, test.payload)
+			}
+
+			if event.Schema != test.schema || event.SchemaVersion != test.schemaVersion {
+				t.Errorf("schema is [%s] version [%d], want [%s] version [%d]", event.Schema, event.SchemaVersion, test.schema, test.schemaVersion)
+			}
+		})
+	}
+}
+
+func TestUpcastStopsTheBatchOnError(t *testing.T) {
+	failure := errors.New("failed")
+	upcasters := Consumer{}.
+		WithUpcaster("order_placed", 1, func(payload json.RawMessage) (json.RawMessage, error) {
+			return nil, failure
+		}).
+		upcasters
+
+	called := false
+	callback := upcast(upcasters, func(ctx context.Context, events ...Event) error {
+		called = true
+		return nil
+	})
+
+	err := callback(context.Background(),
+		Event{EventID: "e1", EventName: "order_shipped", Payload: json.RawMessage(`{}`)},
+		Event{EventID: "e2", EventName: "order_placed", Payload: json.RawMessage(`{}`)},
+	)
+
+	if !errors.Is(err, failure) || !strings.Contains(err.Error(), "e2") {
+		t.Errorf("upcast failed with [%v], want [%v] naming the event", err, failure)
+	}
+
+	if called {
+		t.Error("the callback was called with a batch that failed to upcast")
+	}
+}
+
+func TestWithUpcasterCopiesTheRegistrations(t *testing.T) {
+	base := Consumer{}.WithUpcaster("order_placed", 1, renameField("a", "b"))
+	_ = base.WithUpcaster("order_placed", 2, renameField("b", "c"))
+
+	if len(base.upcasters) != 1 {
+		t.Errorf("registering on a copy changed the original's [%d] upcasters", len(base.upcasters))
+	}
+}
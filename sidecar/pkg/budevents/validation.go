@@ -0,0 +1,101 @@
+package budevents
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ValidationError lists every problem found with a configuration, rather
+// than only the first one
+type ValidationError struct {
+	Problems []string
+}
+
+func (err ValidationError) Error() string {
+	return "invalid configuration: " + strings.Join(err.Problems, "; ")
+}
+
+func (conf Listener) Validate() error {
+	var problems []string
+
+	if conf.BaseURL == "" {
+		problems = append(problems, "base_url is required")
+	} else if u, err := url.Parse(conf.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		problems = append(problems, fmt.Sprintf("base_url [%s] must be an absolute http(s) URL", conf.BaseURL))
+	}
+
+	if conf.WellKnownPath != "" && !strings.HasPrefix(conf.WellKnownPath, "/") {
+		problems = append(problems, fmt.Sprintf("well_known_path [%s] must start with /", conf.WellKnownPath))
+	}
+
+	for _, name := range conf.EventNames {
+		if name == "" || strings.Contains(name, ",") {
+			problems = append(problems, fmt.Sprintf("event_names [%s] must be non-empty and without commas", name))
+		}
+	}
+
+	if conf.Ticker < 0 || conf.MinTicker < 0 || conf.MaxTicker < 0 {
+		problems = append(problems, "tickers must not be negative")
+	}
+
+	if (conf.MinTicker == 0) != (conf.MaxTicker == 0) {
+		problems = append(problems, "min_ticker and max_ticker must be set together")
+	} else if conf.MinTicker > conf.MaxTicker {
+		problems = append(problems, "min_ticker must not be greater than max_ticker")
+	}
+
+	if conf.Jitter < 0 || conf.Jitter > 1 {
+		problems = append(problems, fmt.Sprintf("jitter [%g] must be between 0 and 1", conf.Jitter))
+	}
+
+	switch conf.startPosition() {
+	case StartFromBeginning, StartFromLatest:
+	case StartFromEventID:
+		if conf.LastEventID == "" {
+			problems = append(problems, "last_event_id is required to start from an event ID")
+		}
+	case StartFromTimestamp, StartFromPointInTime:
+		if conf.StartTime.IsZero() {
+			problems = append(problems, "start_time is required to start from a timestamp")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("unknown start_position [%s]", conf.StartPosition))
+	}
+
+	// only the events stream serves ?at=, and a discovered path is its latest
+	if path, _, _ := strings.Cut(conf.WellKnownPath, "?"); conf.startPosition() == StartFromPointInTime && path != "" && path != "/v1/events" {
+		problems = append(problems, fmt.Sprintf("well_known_path [%s] must be /v1/events to start from a point in time", conf.WellKnownPath))
+	}
+
+	if len(problems) > 0 {
+		return ValidationError{Problems: problems}
+	}
+
+	return nil
+}
+
+func validateListeners(listeners []Listener) error {
+	var problems []string
+	seen := map[string]bool{}
+
+	for i, conf := range listeners {
+		if err, ok := conf.Validate().(ValidationError); ok {
+			for _, problem := range err.Problems {
+				problems = append(problems, fmt.Sprintf("listener [%d]: %s", i, problem))
+			}
+		}
+
+		if seen[conf.key()] {
+			problems = append(problems, fmt.Sprintf("listener [%d]: duplicate stream [%s]", i, conf.key()))
+		}
+
+		seen[conf.key()] = true
+	}
+
+	if len(problems) > 0 {
+		return ValidationError{Problems: problems}
+	}
+
+	return nil
+}
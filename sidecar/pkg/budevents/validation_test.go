@@ -0,0 +1,36 @@
+package budevents_test
+
+import (
+	"github.com/thisisbud/backend-events-sidecar/pkg/budevents"
+	"testing"
+	"time"
+)
+
+func TestListenerValidatePointInTimePath(t *testing.T) {
+	tests := []struct {
+		name          string
+		wellKnownPath string
+		valid         bool
+	}{
+		{"discovered path", "", true},
+		{"events stream", "/v1/events", true},
+		{"filtered events stream", "/v1/events?event_name=order_placed", true},
+		{"subject stream", "/v1/subjects/order-1/events", false},
+		{"other path", "/v2/events", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := budevents.Listener{
+				BaseURL:       "http://localhost:8080",
+				WellKnownPath: test.wellKnownPath,
+				StartPosition: budevents.StartFromPointInTime,
+				StartTime:     time.Now(),
+			}.Validate()
+
+			if valid := err == nil; valid != test.valid {
+				t.Errorf("validated with [%v], want valid [%t]", err, test.valid)
+			}
+		})
+	}
+}